@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Authenticator 生成发往代理的认证请求头
+//
+// 普通HTTP请求时请求头直接加在请求上, HTTPS请求时加在CONNECT请求上.
+type Authenticator interface {
+	// Header proxy为代理地址, target为请求目标host:port
+	Header(proxy *url.URL, target string) (http.Header, error)
+}
+
+// Func 以函数形式实现Authenticator
+type Func func(proxy *url.URL, target string) (http.Header, error)
+
+func (f Func) Header(proxy *url.URL, target string) (http.Header, error) {
+	return f(proxy, target)
+}
+
+// Token 通过Proxy-Authorization携带令牌, 如"Bearer xxx"
+type Token struct {
+	// Scheme 认证方式, 默认Bearer
+	Scheme string
+	Token  string
+}
+
+func (t *Token) Header(_ *url.URL, _ string) (http.Header, error) {
+	scheme := t.Scheme
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	header := http.Header{}
+	header.Set("Proxy-Authorization", scheme+" "+t.Token)
+	return header, nil
+}
+
+// StaticHeader 每个请求都携带固定的请求头
+type StaticHeader http.Header
+
+func (h StaticHeader) Header(_ *url.URL, _ string) (http.Header, error) {
+	return http.Header(h).Clone(), nil
+}
+
+// HmacSignature IP白名单类供应商的逐请求签名
+//
+// 请求头为{Prefix}Key, {Prefix}Timestamp, {Prefix}Signature,
+// 签名为hex(HMAC-SHA256(Secret, Key+Timestamp+target)), Timestamp为秒级时间戳.
+type HmacSignature struct {
+	Key    string
+	Secret string
+	// Prefix 请求头前缀, 默认X-Proxy-
+	Prefix string
+	now    func() time.Time
+}
+
+func (s *HmacSignature) Header(_ *url.URL, target string) (http.Header, error) {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "X-Proxy-"
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	ts := strconv.FormatInt(now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write([]byte(s.Key + ts + target))
+	header := http.Header{}
+	header.Set(prefix+"Key", s.Key)
+	header.Set(prefix+"Timestamp", ts)
+	header.Set(prefix+"Signature", hex.EncodeToString(mac.Sum(nil)))
+	return header, nil
+}
+
+// ApplyTransport 使transport发出的CONNECT请求携带认证头, 需在设置transport.Proxy之后调用
+//
+// 代理认证只有一个来源: Authenticator生成Proxy-Authorization时覆盖代理地址中的用户名密码,
+// 此时不再发送用户名密码对应的Basic认证; 否则仍使用代理地址中的用户名密码. 规则与ProxyHeader一致
+func ApplyTransport(transport *http.Transport, a Authenticator) {
+	if a == nil {
+		return
+	}
+	proxyFunc := transport.Proxy
+	if proxyFunc != nil {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			proxy, err := proxyFunc(req)
+			if err != nil || proxy == nil || proxy.User == nil {
+				return proxy, err
+			}
+			header, err := a.Header(proxy, Target(req.URL))
+			if err != nil {
+				return nil, err
+			}
+			if header.Get("Proxy-Authorization") == "" {
+				return proxy, nil
+			}
+			// http.Transport总是用代理地址中的用户名密码覆盖Proxy-Authorization, 需去掉
+			stripped := *proxy
+			stripped.User = nil
+			return &stripped, nil
+		}
+	}
+	transport.GetProxyConnectHeader = func(_ context.Context, proxy *url.URL, target string) (http.Header, error) {
+		return a.Header(proxy, target)
+	}
+}
+
+// ProxyHeader 返回发往代理的认证头, 用于自行发送CONNECT请求
+//
+// 先按代理地址中的用户名密码生成Basic认证, 再以Authenticator生成的请求头覆盖
+func ProxyHeader(proxy *url.URL, target string, a Authenticator) (http.Header, error) {
+	header := http.Header{}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if a == nil {
+		return header, nil
+	}
+	extra, err := a.Header(proxy, target)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		header[k] = v
+	}
+	return header, nil
+}
+
+// ApplyRequest 为经过代理的普通HTTP请求添加认证头, HTTPS请求的认证头由ApplyTransport处理
+//
+// 会修改req.Header, RoundTripper中使用时需先复制请求
+func ApplyRequest(req *http.Request, proxy *url.URL, a Authenticator) error {
+	if a == nil || proxy == nil || req.URL.Scheme != "http" {
+		return nil
+	}
+	header, err := a.Header(proxy, Target(req.URL))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return nil
+}
+
+// Target 返回请求目标host:port
+func Target(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,77 @@
+package event
+
+import "sync"
+
+// Dispatcher 带缓冲的异步事件分发器
+//
+// 事件进入缓冲队列后由单独的goroutine按顺序回调observer, 缓冲区满时阻塞发送方.
+// Close之后的事件会被丢弃.
+type Dispatcher struct {
+	observer Observer
+	queue    chan func()
+	mu       sync.RWMutex
+	closed   bool
+	done     chan struct{}
+}
+
+// NewDispatcher 创建Dispatcher
+//
+// size 缓冲区大小
+func NewDispatcher(observer Observer, size int) *Dispatcher {
+	if size < 0 {
+		size = 0
+	}
+	d := &Dispatcher{
+		observer: observer,
+		queue:    make(chan func(), size),
+		done:     make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for fn := range d.queue {
+		fn()
+	}
+}
+
+func (d *Dispatcher) dispatch(fn func()) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	d.queue <- fn
+}
+
+func (d *Dispatcher) OnFetched(e *FetchedEvent) {
+	d.dispatch(func() { d.observer.OnFetched(e) })
+}
+
+func (d *Dispatcher) OnChecked(e *CheckedEvent) {
+	d.dispatch(func() { d.observer.OnChecked(e) })
+}
+
+func (d *Dispatcher) OnDiscarded(e *DiscardedEvent) {
+	d.dispatch(func() { d.observer.OnDiscarded(e) })
+}
+
+func (d *Dispatcher) OnVendorError(e *VendorErrorEvent) {
+	d.dispatch(func() { d.observer.OnVendorError(e) })
+}
+
+// Close 停止接收事件, 并等待缓冲区中的事件全部回调完成
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		<-d.done
+		return
+	}
+	d.closed = true
+	close(d.queue)
+	d.mu.Unlock()
+	<-d.done
+}
@@ -0,0 +1,133 @@
+package event
+
+import "time"
+
+// DiscardReason 代理被丢弃的原因
+type DiscardReason string
+
+const (
+	// DiscardInvalid 代理格式非法
+	DiscardInvalid DiscardReason = "invalid"
+	// DiscardCheckFailed 代理连通性检查失败
+	DiscardCheckFailed DiscardReason = "check_failed"
+	// DiscardExpired 代理已过期
+	DiscardExpired DiscardReason = "expired"
+	// DiscardBlacklisted 代理被拉黑
+	DiscardBlacklisted DiscardReason = "blacklisted"
+)
+
+// FetchedEvent 从供应商获取到代理
+type FetchedEvent struct {
+	Source  string
+	Proxies []string
+	Time    time.Time
+}
+
+// CheckedEvent 代理完成一次连通性检查
+type CheckedEvent struct {
+	Proxy   string
+	Success bool
+	Err     error
+	Time    time.Time
+}
+
+// DiscardedEvent 代理被丢弃
+type DiscardedEvent struct {
+	Proxy  string
+	Reason DiscardReason
+	Detail string
+	Time   time.Time
+}
+
+// VendorErrorEvent 调用供应商API失败或返回非法内容
+type VendorErrorEvent struct {
+	Source string
+	Url    string
+	Body   string
+	Err    error
+	Time   time.Time
+}
+
+// Observer 代理生命周期观察者
+//
+// 回调在触发事件的goroutine中同步执行, 耗时操作请使用NewDispatcher包装.
+type Observer interface {
+	OnFetched(e *FetchedEvent)
+	OnChecked(e *CheckedEvent)
+	OnDiscarded(e *DiscardedEvent)
+	OnVendorError(e *VendorErrorEvent)
+}
+
+// Funcs 以函数形式实现Observer, 未设置的回调会被忽略
+type Funcs struct {
+	Fetched     func(e *FetchedEvent)
+	Checked     func(e *CheckedEvent)
+	Discarded   func(e *DiscardedEvent)
+	VendorError func(e *VendorErrorEvent)
+}
+
+func (f *Funcs) OnFetched(e *FetchedEvent) {
+	if f.Fetched != nil {
+		f.Fetched(e)
+	}
+}
+
+func (f *Funcs) OnChecked(e *CheckedEvent) {
+	if f.Checked != nil {
+		f.Checked(e)
+	}
+}
+
+func (f *Funcs) OnDiscarded(e *DiscardedEvent) {
+	if f.Discarded != nil {
+		f.Discarded(e)
+	}
+}
+
+func (f *Funcs) OnVendorError(e *VendorErrorEvent) {
+	if f.VendorError != nil {
+		f.VendorError(e)
+	}
+}
+
+// Nop 不做任何处理的Observer
+func Nop() Observer {
+	return &Funcs{}
+}
+
+type multi []Observer
+
+// Multi 将多个Observer合并为一个, 按顺序依次回调
+func Multi(observers ...Observer) Observer {
+	var m multi
+	for _, o := range observers {
+		if o != nil {
+			m = append(m, o)
+		}
+	}
+	return m
+}
+
+func (m multi) OnFetched(e *FetchedEvent) {
+	for _, o := range m {
+		o.OnFetched(e)
+	}
+}
+
+func (m multi) OnChecked(e *CheckedEvent) {
+	for _, o := range m {
+		o.OnChecked(e)
+	}
+}
+
+func (m multi) OnDiscarded(e *DiscardedEvent) {
+	for _, o := range m {
+		o.OnDiscarded(e)
+	}
+}
+
+func (m multi) OnVendorError(e *VendorErrorEvent) {
+	for _, o := range m {
+		o.OnVendorError(e)
+	}
+}
@@ -0,0 +1,53 @@
+package event
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	convey.Convey("Multi", t, func() {
+		convey.Convey("Every observer is notified and nil observers are skipped.", func() {
+			var fetched []string
+			o1 := &Funcs{Fetched: func(e *FetchedEvent) { fetched = append(fetched, "o1") }}
+			o2 := &Funcs{Fetched: func(e *FetchedEvent) { fetched = append(fetched, "o2") }}
+			Multi(o1, nil, o2).OnFetched(&FetchedEvent{})
+			convey.So(fetched, convey.ShouldResemble, []string{"o1", "o2"})
+		})
+
+		convey.Convey("Unset callbacks are ignored.", func() {
+			convey.So(func() { Nop().OnChecked(&CheckedEvent{}) }, convey.ShouldNotPanic)
+		})
+	})
+}
+
+func TestDispatcher(t *testing.T) {
+	convey.Convey("Dispatcher", t, func() {
+		convey.Convey("Buffered events are delivered in order before Close returns.", func() {
+			var (
+				mu      sync.Mutex
+				proxies []string
+			)
+			d := NewDispatcher(&Funcs{Discarded: func(e *DiscardedEvent) {
+				mu.Lock()
+				proxies = append(proxies, e.Proxy)
+				mu.Unlock()
+			}}, 2)
+			for _, p := range []string{"a", "b", "c"} {
+				d.OnDiscarded(&DiscardedEvent{Proxy: p, Reason: DiscardExpired})
+			}
+			d.Close()
+			convey.So(proxies, convey.ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		convey.Convey("Events after Close are dropped.", func() {
+			var count int
+			d := NewDispatcher(&Funcs{VendorError: func(e *VendorErrorEvent) { count++ }}, 1)
+			d.Close()
+			d.OnVendorError(&VendorErrorEvent{})
+			d.Close()
+			convey.So(count, convey.ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package geo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RangeDB 基于IP段的离线地理位置库
+type RangeDB struct {
+	ranges []*ipRange
+}
+
+type ipRange struct {
+	start net.IP
+	end   net.IP
+	loc   *Location
+}
+
+// LoadCSV 从CSV读取IP段库
+//
+// 每行格式为 start,end,country,region,asn,org, 其中start也可以是CIDR, 此时end留空.
+// asn可以带AS前缀, region, asn, org可以省略. 以#开头的行会被忽略, 首条记录为上述列名时作为表头忽略.
+// IP段之间不能重叠. 错误信息中的行号为文件中的实际行号.
+func LoadCSV(r io.Reader) (*RangeDB, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	db := &RangeDB{}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && isHeader(record) {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("第%d行字段数量不足", line)
+		}
+		start, end, err := parseRange(record[0], record[1])
+		if err != nil {
+			return nil, fmt.Errorf("第%d行IP段非法. %v", line, err)
+		}
+		loc := &Location{Country: strings.ToUpper(record[2])}
+		if len(record) > 3 {
+			loc.Region = record[3]
+		}
+		if len(record) > 4 && record[4] != "" {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(record[4]), "AS"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("第%d行ASN非法. %v", line, err)
+			}
+			loc.ASN = uint32(asn)
+		}
+		if len(record) > 5 {
+			loc.Org = record[5]
+		}
+		db.ranges = append(db.ranges, &ipRange{start: start, end: end, loc: loc})
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	// Lookup依赖段之间不重叠
+	for i := 1; i < len(db.ranges); i++ {
+		prev, cur := db.ranges[i-1], db.ranges[i]
+		if bytes.Compare(cur.start, prev.end) <= 0 {
+			return nil, fmt.Errorf("IP段重叠. %s-%s, %s-%s", prev.start, prev.end, cur.start, cur.end)
+		}
+	}
+	return db, nil
+}
+
+// OpenCSV 从文件读取IP段库, 格式见LoadCSV
+func OpenCSV(path string) (*RangeDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadCSV(file)
+}
+
+// Lookup 实现Lookup
+func (db *RangeDB) Lookup(ip net.IP) (*Location, error) {
+	ip = ip.To16()
+	if ip == nil {
+		return nil, ErrNotFound
+	}
+	// 段之间不重叠, 只有start不大于ip的最后一段可能包含ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	})
+	if i > 0 && bytes.Compare(ip, db.ranges[i-1].end) <= 0 {
+		return db.ranges[i-1].loc, nil
+	}
+	return nil, ErrNotFound
+}
+
+// csvColumns LoadCSV的列名
+var csvColumns = []string{"start", "end", "country", "region", "asn", "org"}
+
+// isHeader 记录是否为表头, 列名按顺序与csvColumns一致, 可以省略末尾的列
+func isHeader(record []string) bool {
+	if len(record) < 3 || len(record) > len(csvColumns) {
+		return false
+	}
+	for i, field := range record {
+		if !strings.EqualFold(strings.TrimSpace(field), csvColumns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseRange(start string, end string) (net.IP, net.IP, error) {
+	if strings.Contains(start, "/") {
+		_, network, err := net.ParseCIDR(start)
+		if err != nil {
+			return nil, nil, err
+		}
+		first := network.IP.To16()
+		last := make(net.IP, len(first))
+		mask := network.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.IPMask(bytes.Repeat([]byte{0xff}, 12)), mask...)
+		}
+		for i := range first {
+			last[i] = first[i] | ^mask[i]
+		}
+		return first, last, nil
+	}
+	startIp, endIp := net.ParseIP(start), net.ParseIP(end)
+	if startIp == nil || endIp == nil {
+		return nil, nil, fmt.Errorf("start=%s, end=%s", start, end)
+	}
+	startIp, endIp = startIp.To16(), endIp.To16()
+	if bytes.Compare(startIp, endIp) > 0 {
+		return nil, nil, fmt.Errorf("start=%s大于end=%s", start, end)
+	}
+	return startIp, endIp, nil
+}
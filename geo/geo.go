@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrNotFound 未找到IP对应的地理位置
+var ErrNotFound = errors.New("ip location not found")
+
+// Location IP地理位置
+type Location struct {
+	// Country ISO 3166-1两位国家代码, 如US, CN
+	Country string
+	// Region 省/州
+	Region string
+	// ASN 自治系统号
+	ASN uint32
+	// Org 运营商或组织名称
+	Org string
+}
+
+// Lookup 查询IP地理位置
+type Lookup interface {
+	Lookup(ip net.IP) (*Location, error)
+}
+
+// Filter 按地理位置过滤, 空列表表示不限制
+//
+// 国家与地区比较时忽略大小写, 拒绝列表优先于允许列表
+type Filter struct {
+	AllowCountries []string
+	DenyCountries  []string
+	AllowRegions   []string
+	DenyRegions    []string
+	AllowASNs      []uint32
+	DenyASNs       []uint32
+}
+
+// Match 地理位置是否满足过滤条件, 不满足时返回原因
+func (f *Filter) Match(loc *Location) (ok bool, reason string) {
+	if f == nil {
+		return true, ""
+	}
+	if loc == nil {
+		loc = &Location{}
+	}
+	if containsFold(f.DenyCountries, loc.Country) {
+		return false, "country " + loc.Country + " is denied"
+	}
+	if len(f.AllowCountries) > 0 && !containsFold(f.AllowCountries, loc.Country) {
+		return false, "country " + loc.Country + " is not allowed"
+	}
+	if containsFold(f.DenyRegions, loc.Region) {
+		return false, "region " + loc.Region + " is denied"
+	}
+	if len(f.AllowRegions) > 0 && !containsFold(f.AllowRegions, loc.Region) {
+		return false, "region " + loc.Region + " is not allowed"
+	}
+	if containsASN(f.DenyASNs, loc.ASN) {
+		return false, "asn is denied"
+	}
+	if len(f.AllowASNs) > 0 && !containsASN(f.AllowASNs, loc.ASN) {
+		return false, "asn is not allowed"
+	}
+	return true, ""
+}
+
+func containsFold(vec []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, v := range vec {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsASN(vec []uint32, asn uint32) bool {
+	if asn == 0 {
+		return false
+	}
+	for _, v := range vec {
+		if v == asn {
+			return true
+		}
+	}
+	return false
+}
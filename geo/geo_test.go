@@ -0,0 +1,108 @@
+package geo
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"net"
+	"strings"
+	"testing"
+)
+
+const rangeCsv = `start,end,country,region,asn,org
+# comment
+1.0.0.0,1.0.0.255,au,Queensland,AS13335,Cloudflare
+10.0.0.0/8,,CN,Beijing,4808,China Unicom
+2001:db8::/32,,US,California,64500
+`
+
+func TestLoadCSV(t *testing.T) {
+	convey.Convey("LoadCSV", t, func() {
+		db, err := LoadCSV(strings.NewReader(rangeCsv))
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.Convey("Lookup ip in range.", func() {
+			loc, err := db.Lookup(net.ParseIP("1.0.0.8"))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(*loc, convey.ShouldResemble, Location{Country: "AU", Region: "Queensland", ASN: 13335, Org: "Cloudflare"})
+		})
+
+		convey.Convey("Lookup ip in cidr.", func() {
+			loc, err := db.Lookup(net.ParseIP("10.255.255.255"))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(loc.Country, convey.ShouldEqual, "CN")
+
+			loc, err = db.Lookup(net.ParseIP("2001:db8:1::1"))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(loc.ASN, convey.ShouldEqual, 64500)
+		})
+
+		convey.Convey("Lookup ip out of range.", func() {
+			for _, ip := range []string{"1.0.1.0", "0.255.255.255", "11.0.0.0", "2001:db9::1"} {
+				_, err := db.Lookup(net.ParseIP(ip))
+				convey.So(err, convey.ShouldEqual, ErrNotFound)
+			}
+		})
+
+		convey.Convey("Invalid range.", func() {
+			_, err := LoadCSV(strings.NewReader("1.0.0.0,1.0.0.255,AU\n1.0.1.255,1.0.1.0,AU"))
+			convey.So(err, convey.ShouldBeError)
+		})
+
+		convey.Convey("A malformed first row is not skipped as a header.", func() {
+			_, err := LoadCSV(strings.NewReader("1.0.0.x,1.0.0.255,AU\n1.0.1.0,1.0.1.255,AU"))
+			convey.So(err, convey.ShouldBeError)
+			convey.So(err.Error(), convey.ShouldStartWith, "第1行")
+		})
+
+		convey.Convey("Line numbers count comments.", func() {
+			_, err := LoadCSV(strings.NewReader("start,end,country\n# comment\n\n1.0.0.0,1.0.0.x,AU"))
+			convey.So(err, convey.ShouldBeError)
+			convey.So(err.Error(), convey.ShouldStartWith, "第4行")
+		})
+
+		convey.Convey("Overlapping ranges.", func() {
+			_, err := LoadCSV(strings.NewReader("1.0.0.0,1.0.0.255,AU\n1.0.0.0/16,,CN"))
+			convey.So(err, convey.ShouldBeError)
+			_, err = LoadCSV(strings.NewReader("1.0.0.0,1.0.0.255,AU\n1.0.0.255,1.0.1.0,CN"))
+			convey.So(err, convey.ShouldBeError)
+			_, err = LoadCSV(strings.NewReader("1.0.1.0,1.0.1.255,AU\n1.0.0.0,1.0.0.255,CN"))
+			convey.So(err, convey.ShouldBeNil)
+		})
+	})
+}
+
+func TestFilter_Match(t *testing.T) {
+	convey.Convey("Match", t, func() {
+		loc := &Location{Country: "US", Region: "California", ASN: 64500}
+
+		convey.Convey("Empty filter matches everything.", func() {
+			ok, _ := (&Filter{}).Match(loc)
+			convey.So(ok, convey.ShouldBeTrue)
+			ok, _ = (*Filter)(nil).Match(nil)
+			convey.So(ok, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Countries.", func() {
+			ok, _ := (&Filter{AllowCountries: []string{"us"}}).Match(loc)
+			convey.So(ok, convey.ShouldBeTrue)
+			ok, _ = (&Filter{AllowCountries: []string{"CN"}}).Match(loc)
+			convey.So(ok, convey.ShouldBeFalse)
+			ok, _ = (&Filter{DenyCountries: []string{"US"}}).Match(loc)
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Regions and asns.", func() {
+			ok, _ := (&Filter{DenyRegions: []string{"california"}}).Match(loc)
+			convey.So(ok, convey.ShouldBeFalse)
+			ok, _ = (&Filter{AllowASNs: []uint32{64500}}).Match(loc)
+			convey.So(ok, convey.ShouldBeTrue)
+			ok, reason := (&Filter{DenyASNs: []uint32{64500}}).Match(loc)
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(reason, convey.ShouldNotBeEmpty)
+		})
+
+		convey.Convey("Unknown location fails allow lists.", func() {
+			ok, _ := (&Filter{AllowCountries: []string{"US"}}).Match(nil)
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+	})
+}
@@ -1,7 +1,14 @@
 package adapter
 
-import "context"
+import (
+	"context"
+	"github.com/zx106kg/go-proxy/auth"
+)
 
+// ProxyVendorAdapter 代理供应商适配器
+//
+// 同步批量获取方法出错时可能同时返回已获取的代理, 如超出预算时返回预算内已获取的代理与budget.ErrExceeded.
+// 这些代理已计入花费, 调用方应照常使用, 只有没有返回任何代理时才视为获取失败.
 type ProxyVendorAdapter interface {
 	GetProxy(ctx context.Context, exitWhenError bool) (proxy string, err error)
 	GetProxiesSync(ctx context.Context, count int, exitWhenError bool) (proxies []string, err error)
@@ -9,3 +16,15 @@ type ProxyVendorAdapter interface {
 	GetProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chProxy chan string, chErr chan error)
 	GetCheckedProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chProxy chan string, chErr chan error)
 }
+
+// Authenticated 代理需要额外认证请求头时, 供应商适配器实现此接口
+type Authenticated interface {
+	Authenticator() auth.Authenticator
+}
+
+// FixedEndpoint 供应商每次返回相同的代理地址, 由供应商在同一地址后轮换出口IP时(如未使用会话的隧道), 适配器实现此接口
+//
+// 代理池等按地址记录退役代理的使用方据此不再拒绝重新加入该地址
+type FixedEndpoint interface {
+	FixedEndpoint() bool
+}
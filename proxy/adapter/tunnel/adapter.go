@@ -1,48 +1,341 @@
 package tunnel
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/logger"
+	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/util"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
 
 type Tunnel struct {
-	url string
+	url              string
+	usernameTemplate string
+	password         string
+	sessionId        func() string
+	changeIpUrl      string
+	ipEchoUrl        string
+	auth             auth.Authenticator
+	checker          *util.Checker
+	logger           logger.Logger
+	client           *http.Client
 }
 
 type CreateConfig struct {
 	Url string
+	// UsernameTemplate 用户名模板, ${session}会被替换为会话ID, 如 user-session-${session}-country-us.
+	// 为空时始终返回Url
+	UsernameTemplate string
+	// Password 隧道密码, 为空时使用Url中的密码
+	Password string
+	// SessionIdGenerator 会话ID生成函数, 默认生成16位随机十六进制串
+	SessionIdGenerator func() string
+	// ChangeIpUrl 供应商切换出口IP的接口地址. 未设置UsernameTemplate时, GetProxy及各批量获取方法每次调用(每批)先调用一次此接口
+	ChangeIpUrl string
+	// IpEchoUrl 检查会话出口IP时请求的接口, 默认http://httpbin.org/ip
+	IpEchoUrl string
+	// Authenticator 代理认证, 如Proxy-Authorization令牌, 检查时同样使用
+	Authenticator auth.Authenticator
+	// Checker 检查隧道使用的检查器, 为空时按Authenticator创建
+	Checker *util.Checker
+	// Client 调用ChangeIpUrl使用的http.Client, 默认超时5秒
+	Client *http.Client
+	Logger logger.Logger
 }
 
 func NewTunnel(config *CreateConfig) *Tunnel {
+	sessionId := config.SessionIdGenerator
+	if sessionId == nil {
+		sessionId = randomSessionId
+	}
+	ipEchoUrl := config.IpEchoUrl
+	if ipEchoUrl == "" {
+		ipEchoUrl = "http://httpbin.org/ip"
+	}
+	log := config.Logger
+	if log == nil {
+		log = console.NewLogger()
+	}
+	checker := config.Checker
+	if checker == nil {
+		checker = util.NewChecker(&util.CheckerOptions{Authenticator: config.Authenticator})
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
 	return &Tunnel{
-		url: config.Url,
+		url:              config.Url,
+		usernameTemplate: config.UsernameTemplate,
+		password:         config.Password,
+		sessionId:        sessionId,
+		changeIpUrl:      config.ChangeIpUrl,
+		ipEchoUrl:        ipEchoUrl,
+		auth:             config.Authenticator,
+		checker:          checker,
+		logger:           log,
+		client:           client,
 	}
 }
 
-func (t *Tunnel) GetProxy(ctx context.Context, _ bool) (proxy string, err error) {
-	return t.url, nil
+// Authenticator 代理认证, 实现adapter.Authenticated
+func (t *Tunnel) Authenticator() auth.Authenticator {
+	return t.auth
+}
+
+// FixedEndpoint 未设置UsernameTemplate时始终返回Url, 实现adapter.FixedEndpoint
+func (t *Tunnel) FixedEndpoint() bool {
+	return t.usernameTemplate == ""
 }
 
-func (t *Tunnel) GetProxiesSync(ctx context.Context, count int, _ bool) (proxies []string, err error) {
+func (t *Tunnel) GetProxy(ctx context.Context, exitWhenError bool) (proxy string, err error) {
+	if err := t.rotate(ctx, exitWhenError); err != nil {
+		return "", err
+	}
+	return t.nextProxy()
+}
+
+// GetProxiesSync 同步批量获取代理
+//
+// 设置UsernameTemplate时, 每个代理使用不同的会话ID, 对应不同的出口IP;
+// 未设置时每批先调用一次ChangeIpUrl切换出口IP, 同一批代理共用切换后的出口IP
+func (t *Tunnel) GetProxiesSync(ctx context.Context, count int, exitWhenError bool) (proxies []string, err error) {
+	if err := t.rotate(ctx, exitWhenError); err != nil {
+		return nil, err
+	}
 	for i := 0; i < count; i++ {
-		proxies = append(proxies, t.url)
+		proxy, err := t.nextProxy()
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, proxy)
 	}
 	return proxies, nil
 }
 
+// GetCheckedProxiesSync 同步批量获取已检查的代理
+//
+// 未设置UsernameTemplate时检查隧道连通性; 设置时检查每个会话的出口IP, 并保证出口IP互不相同
 func (t *Tunnel) GetCheckedProxiesSync(ctx context.Context, count int, exitWhenError bool) (proxies []string, err error) {
-	return t.GetProxiesSync(ctx, count, exitWhenError)
+	chProxy, chErr := t.GetCheckedProxiesAsync(ctx, count, exitWhenError)
+	for {
+		select {
+		case proxy, open := <-chProxy:
+			if !open {
+				return proxies, nil
+			}
+			proxies = append(proxies, proxy)
+		case err := <-chErr:
+			return nil, err
+		}
+	}
 }
 
-func (t *Tunnel) GetProxiesAsync(ctx context.Context, count int, _ bool) (chProxy chan string, chErr chan error) {
+func (t *Tunnel) GetProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chProxy chan string, chErr chan error) {
 	chProxy = make(chan string)
 	chErr = make(chan error)
 	go func() {
+		if err := t.rotate(ctx, exitWhenError); err != nil {
+			chErr <- err
+			return
+		}
 		for i := 0; i < count; i++ {
-			chProxy <- t.url
+			proxy, err := t.nextProxy()
+			if err != nil {
+				chErr <- err
+				return
+			}
+			chProxy <- proxy
 		}
 		close(chProxy)
 	}()
 	return chProxy, chErr
 }
 
+// GetCheckedProxiesAsync 异步批量获取已检查的代理
+//
+// chProxy 成功的代理通过此channel返回
+//
+// chErr 异常通过此channel返回
 func (t *Tunnel) GetCheckedProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chProxy chan string, chErr chan error) {
-	return t.GetProxiesAsync(ctx, count, exitWhenError)
+	chProxy = make(chan string)
+	chErr = make(chan error)
+	go func() {
+		if t.usernameTemplate == "" {
+			if err := t.rotate(ctx, exitWhenError); err != nil {
+				chErr <- err
+				return
+			}
+			if err := t.waitConn(ctx, exitWhenError); err != nil {
+				chErr <- err
+				return
+			}
+			for i := 0; i < count; i++ {
+				chProxy <- t.url
+			}
+			close(chProxy)
+			return
+		}
+
+		exitIps := map[string]bool{}
+		var current int
+		for current < count {
+			if ctx != nil && ctx.Err() != nil {
+				chErr <- ctx.Err()
+				return
+			}
+			var failed bool
+			for _, r := range t.checkSessions(ctx, count-current) {
+				if r.err == nil && exitIps[r.ip] {
+					r.err = fmt.Errorf("隧道会话出口IP重复. ip=%s", r.ip)
+				}
+				if r.err != nil {
+					t.logger.Warn(fmt.Sprintf("[Tunnel] 隧道会话检查失败. proxy=%s, %v", r.proxy, r.err))
+					if exitWhenError {
+						chErr <- r.err
+						return
+					}
+					failed = true
+					continue
+				}
+				exitIps[r.ip] = true
+				chProxy <- r.proxy
+				current++
+			}
+			if failed && current < count {
+				t.sleep(ctx, 1*time.Second)
+			}
+		}
+		close(chProxy)
+	}()
+	return chProxy, chErr
+}
+
+// rotate 未使用会话时调用ChangeIpUrl切换出口IP, exitWhenError为false时忽略切换失败
+func (t *Tunnel) rotate(ctx context.Context, exitWhenError bool) error {
+	if t.usernameTemplate != "" || t.changeIpUrl == "" {
+		return nil
+	}
+	if err := t.ChangeIp(ctx); err != nil {
+		t.logger.Warn(fmt.Sprintf("[Tunnel] 切换出口IP失败. %v", err))
+		if exitWhenError {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChangeIp 调用供应商接口切换隧道出口IP
+func (t *Tunnel) ChangeIp(ctx context.Context) error {
+	if t.changeIpUrl == "" {
+		return errors.New("未配置切换IP接口")
+	}
+	req, err := http.NewRequest("GET", t.changeIpUrl, nil)
+	if err != nil {
+		return err
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("调用切换IP接口返回状态码异常, StatusCode=%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// waitConn 检查隧道连通性, 不退出时持续重试直到成功或ctx结束
+func (t *Tunnel) waitConn(ctx context.Context, exitWhenError bool) error {
+	for {
+		r := t.checker.Check(ctx, t.url)
+		if r.Success {
+			return nil
+		}
+		err := r.Err
+		t.logger.Warn(fmt.Sprintf("[Tunnel] 隧道连通性检查失败. %v", err))
+		if exitWhenError {
+			return err
+		}
+		if ctx != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		t.sleep(ctx, 1*time.Second)
+	}
+}
+
+type sessionResult struct {
+	proxy string
+	ip    string
+	err   error
+}
+
+// checkSessions 生成count个会话代理, 并发获取各自的出口IP
+func (t *Tunnel) checkSessions(ctx context.Context, count int) []*sessionResult {
+	results := make([]*sessionResult, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		proxy, err := t.nextProxy()
+		if err != nil {
+			results[i] = &sessionResult{err: err}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, proxy string) {
+			defer wg.Done()
+			ip, err := t.checker.GetExitIp(ctx, proxy, t.ipEchoUrl)
+			results[i] = &sessionResult{proxy: proxy, ip: ip, err: err}
+		}(i, proxy)
+	}
+	wg.Wait()
+	return results
+}
+
+func (t *Tunnel) sleep(ctx context.Context, d time.Duration) {
+	if ctx == nil {
+		time.Sleep(d)
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// nextProxy 生成代理连接串, 设置UsernameTemplate时带上新的会话ID
+func (t *Tunnel) nextProxy() (string, error) {
+	if t.usernameTemplate == "" {
+		return t.url, nil
+	}
+	parsed, err := url.Parse(t.url)
+	if err != nil {
+		return "", fmt.Errorf("隧道地址格式错误. %+v", err)
+	}
+	password := t.password
+	if password == "" && parsed.User != nil {
+		password, _ = parsed.User.Password()
+	}
+	username := strings.ReplaceAll(t.usernameTemplate, `${session}`, t.sessionId())
+	parsed.User = url.UserPassword(username, password)
+	return parsed.String(), nil
+}
+
+func randomSessionId() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
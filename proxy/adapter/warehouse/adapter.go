@@ -4,32 +4,81 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/event"
 	"github.com/zx106kg/go-proxy/logger"
 	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/proxy/budget"
 	"github.com/zx106kg/go-proxy/util"
 	"io"
 	"net/http"
-	"strconv"
+	"net/url"
 	"strings"
 	"sync/atomic"
 	"time"
 )
 
 type Warehouse struct {
-	url      string
-	username string
-	password string
-	splitter string
-	logger   logger.Logger
-	client   *http.Client
+	url       string
+	username  string
+	password  string
+	splitter  string
+	formats   []util.LineFormat
+	lenient   bool
+	logger    logger.Logger
+	observer  event.Observer
+	checker   *util.Checker
+	auth      auth.Authenticator
+	country   string
+	protocol  string
+	signer    Signer
+	method    string
+	header    http.Header
+	body      string
+	whitelist *Whitelist
+	budget    *budget.Budget
+	now       func() time.Time
+	client    *http.Client
 }
 
+var errInvalidBody = errors.New("供应商API返回非法文本")
+
 type CreateConfig struct {
+	// Url 代理API地址, 支持占位符${num}, ${ts}, ${nonce}, ${country}, ${protocol}, 替换的值会经过QueryEscape编码
 	Url      string
 	Username string
 	Password string
+	// Splitter 分隔符, 默认\r\n. 设置为util.SplitterAuto时自动识别
 	Splitter string
-	Logger   logger.Logger
+	// LineFormats 原始代理行格式, 为空时只接受host:port. 行内自带的用户名密码优先于Username和Password
+	LineFormats []util.LineFormat
+	// Lenient 宽松解析, 跳过非法行而不是丢弃整个返回结果
+	Lenient bool
+	Logger  logger.Logger
+	// Observer 代理生命周期事件回调, 为空时不回调
+	Observer event.Observer
+	// Checker GetChecked*使用的检查器, 为空时只检查连通性
+	Checker *util.Checker
+	// Authenticator 代理认证, 如Proxy-Authorization令牌. 未设置Checker时也用于检查
+	Authenticator auth.Authenticator
+	// Country 替换占位符${country}
+	Country string
+	// Protocol 替换占位符${protocol}
+	Protocol string
+	// Signer 每次调用API时计算签名参数, 为空时不签名
+	Signer Signer
+	// Method 调用API的请求方法, 默认GET
+	Method string
+	// Header 调用API时携带的请求头, 如API Key, User-Agent
+	Header http.Header
+	// Body 请求体模板, 支持与Url相同的占位符. 未设置Content-Type时, 以{开头按JSON发送, 否则按表单发送并编码替换的值
+	Body string
+	// Whitelist API返回白名单错误时自动登记本机出口IP并重试, 为空时不处理
+	Whitelist *Whitelist
+	// Client 调用API使用的http.Client, 默认超时5秒
+	Client *http.Client
+	// Budget 代理获取预算, 为空时不限制. 超出时按Budget配置阻塞或返回*budget.ExceededError
+	Budget *budget.Budget
 }
 
 // NewWarehouse 创建StandardProxyFetcher
@@ -42,16 +91,51 @@ func NewWarehouse(config *CreateConfig) *Warehouse {
 	if log == nil {
 		log = console.NewLogger()
 	}
+	observer := config.Observer
+	if observer == nil {
+		observer = event.Nop()
+	}
+	checker := config.Checker
+	if checker == nil {
+		checker = util.NewChecker(&util.CheckerOptions{Authenticator: config.Authenticator})
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	method := config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
 	return &Warehouse{
-		url:      config.Url,
-		username: config.Username,
-		password: config.Password,
-		splitter: splitter,
-		logger:   log,
-		client:   &http.Client{Timeout: 5 * time.Second},
+		url:       config.Url,
+		username:  config.Username,
+		password:  config.Password,
+		splitter:  splitter,
+		formats:   config.LineFormats,
+		lenient:   config.Lenient,
+		logger:    log,
+		observer:  observer,
+		checker:   checker,
+		auth:      config.Authenticator,
+		country:   config.Country,
+		protocol:  config.Protocol,
+		signer:    config.Signer,
+		method:    method,
+		header:    config.Header,
+		body:      config.Body,
+		whitelist: config.Whitelist,
+		budget:    config.Budget,
+		now:       time.Now,
+		client:    client,
 	}
 }
 
+// Authenticator 代理认证, 实现adapter.Authenticated
+func (f *Warehouse) Authenticator() auth.Authenticator {
+	return f.auth
+}
+
 // GetProxy 获取一个代理
 func (f *Warehouse) GetProxy(ctx context.Context, exitWhenError bool) (proxy string, err error) {
 	proxies, err := f.GetProxiesSync(ctx, 1, exitWhenError)
@@ -66,50 +150,51 @@ func (f *Warehouse) GetProxy(ctx context.Context, exitWhenError bool) (proxy str
 // count 获取数量
 //
 // exitWhenError 当调用api失败时是否立刻结束
+//
+// 超出Budget时返回已获取的代理以及*budget.ExceededError
 func (f *Warehouse) GetProxiesSync(ctx context.Context, count int, exitWhenError bool) (proxies []string, err error) {
-	for {
-		if len(proxies) >= count {
-			break
-		}
-		// 获取匹配获取数量的url
-		apiUrl := f.replaceNumPlaceholder(count - len(proxies))
-		body, err := f.callApi(ctx, apiUrl)
+	for len(proxies) < count {
+		tProxies, err := f.fetch(ctx, count-len(proxies))
 		if err != nil {
-			if f.logger != nil {
-				f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 调用代理供应商API失败. %v", err))
-			}
 			if ctx != nil && ctx.Err() == context.Canceled {
 				return nil, err
 			}
+			if errors.Is(err, budget.ErrExceeded) {
+				// 已获取的代理已计入花费, 与错误一起返回
+				return proxies, err
+			}
 			if exitWhenError {
 				return nil, err
 			}
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		if !util.IsContainsProxyOnly(body, f.splitter) {
-			f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 供应商API返回非法文本. 原文: %s", body))
-			if exitWhenError {
-				return nil, fmt.Errorf("供应商API返回非法文本. 原文: %s", body)
+			if !errors.Is(err, errInvalidBody) {
+				time.Sleep(1 * time.Second)
 			}
 			continue
 		}
-		tProxies := util.GetProxyFromBody(body, f.splitter)
-		tProxies = f.formatRawProxies(tProxies)
 		proxies = append(proxies, tProxies...)
 	}
 	return proxies, nil
 }
 
 // GetCheckedProxiesSync 同步批量获取已检查的代理
+//
+// 超出Budget时返回已检查通过的代理以及*budget.ExceededError
 func (f *Warehouse) GetCheckedProxiesSync(ctx context.Context, count int, exitWhenError bool) (proxies []string, err error) {
 	for {
 		tProxies, err := f.GetProxiesSync(ctx, count, exitWhenError)
-		if err != nil {
+		if err != nil && !errors.Is(err, budget.ErrExceeded) {
 			return nil, err
 		}
-		succ, _ := util.CheckProxiesConnSync(ctx, tProxies)
-		proxies = append(proxies, succ...)
+		succ, fail := f.checker.CheckSync(ctx, tProxies, f.observer)
+		for _, r := range fail {
+			f.discard([]string{r.Proxy}, event.DiscardCheckFailed, r.Err.Error())
+		}
+		for _, r := range succ {
+			proxies = append(proxies, r.Proxy)
+		}
+		if err != nil {
+			return proxies, err
+		}
 		if len(proxies) >= count {
 			return proxies, nil
 		}
@@ -132,30 +217,17 @@ func (f *Warehouse) GetProxiesAsync(ctx context.Context, count int, exitWhenErro
 				close(chProxy)
 				return
 			}
-			// 获取匹配获取数量的url
-			apiUrl := f.replaceNumPlaceholder(count - int(current.Load()))
-			body, err := f.callApi(ctx, apiUrl)
+			proxies, err := f.fetch(ctx, count-int(current.Load()))
 			if err != nil {
-				if f.logger != nil {
-					f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 调用代理供应商API失败. %v", err))
-				}
-				if exitWhenError {
+				if exitWhenError || errors.Is(err, budget.ErrExceeded) {
 					chErr <- err
 					return
 				}
-				time.Sleep(1 * time.Second)
-				continue
-			}
-			if !util.IsContainsProxyOnly(body, f.splitter) {
-				f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 供应商API返回非法文本. 原文: %s", body))
-				if exitWhenError {
-					chErr <- fmt.Errorf("供应商API返回非法文本. 原文: %s", body)
-					return
+				if !errors.Is(err, errInvalidBody) {
+					time.Sleep(1 * time.Second)
 				}
 				continue
 			}
-			proxies := util.GetProxyFromBody(body, f.splitter)
-			proxies = f.formatRawProxies(proxies)
 			for _, proxy := range proxies {
 				chProxy <- proxy
 				current.Add(1)
@@ -182,32 +254,19 @@ func (f *Warehouse) GetCheckedProxiesAsync(ctx context.Context, count int, exitW
 				close(chProxy)
 				return
 			}
-			// 获取匹配获取数量的url
-			apiUrl := f.replaceNumPlaceholder(count - int(current.Load()))
-			body, err := f.callApi(ctx, apiUrl)
+			proxies, err := f.fetch(ctx, count-int(current.Load()))
 			if err != nil {
-				if f.logger != nil {
-					f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 调用代理供应商API失败. %v", err))
-				}
-				if exitWhenError {
+				if exitWhenError || errors.Is(err, budget.ErrExceeded) {
 					chErr <- err
 					return
 				}
-				time.Sleep(1 * time.Second)
-				continue
-			}
-			if !util.IsContainsProxyOnly(body, f.splitter) {
-				f.logger.Warn(fmt.Sprintf("[GetProxiesSync] 供应商API返回非法文本. 原文: %s", body))
-				if exitWhenError {
-					chErr <- fmt.Errorf("供应商API返回非法文本. 原文: %s", body)
-					return
+				if !errors.Is(err, errInvalidBody) {
+					time.Sleep(1 * time.Second)
 				}
 				continue
 			}
-			proxies := util.GetProxyFromBody(body, f.splitter)
-			proxies = f.formatRawProxies(proxies)
-			chResult := make(chan *util.CheckProxyConnAsyncResult)
-			util.CheckProxiesConnAsync(ctx, proxies, chResult)
+			chResult := make(chan *util.CheckResult)
+			f.checker.CheckAsync(ctx, proxies, chResult, f.observer)
 			var tcount int
 			for tcount < len(proxies) {
 				r := <-chResult
@@ -215,6 +274,8 @@ func (f *Warehouse) GetCheckedProxiesAsync(ctx context.Context, count int, exitW
 				if r.Success {
 					chProxy <- r.Proxy
 					current.Add(1)
+				} else {
+					f.discard([]string{r.Proxy}, event.DiscardCheckFailed, r.Err.Error())
 				}
 			}
 		}
@@ -223,31 +284,167 @@ func (f *Warehouse) GetCheckedProxiesAsync(ctx context.Context, count int, exitW
 	return chProxy, chErr
 }
 
-// replaceNumPlaceholder 使用count替换配置url中的占位符${num}, 生成实际的代理获取url
-func (f *Warehouse) replaceNumPlaceholder(count int) string {
-	if !strings.Contains(f.url, `${num}`) {
-		return f.url
+// fetch 调用一次供应商API, 返回格式化后的代理
+//
+// 返回文本非法时, err包装errInvalidBody
+func (f *Warehouse) fetch(ctx context.Context, count int) (proxies []string, err error) {
+	// returned 供应商返回的代理行数, 包含非法行
+	var returned int
+	if f.budget != nil {
+		granted, commit, err := f.budget.Reserve(ctx, count)
+		if err != nil {
+			f.logger.Warn(fmt.Sprintf("[Warehouse] %v", err))
+			return nil, err
+		}
+		count = granted
+		// 按供应商实际返回的数量计入花费, 解析或格式化时丢弃的代理同样已付费
+		defer func() {
+			commit(returned)
+		}()
+	}
+	// 获取匹配获取数量并签名的url
+	values := f.placeholders(count)
+	apiUrl, err := buildApiUrl(f.url, f.signer, values)
+	if err != nil {
+		f.logger.Warn(fmt.Sprintf("[Warehouse] 生成代理供应商API地址失败. %v", err))
+		return nil, err
+	}
+	reqBody := f.renderBody(values)
+	body, err := f.callApi(ctx, apiUrl, reqBody)
+	if f.whitelist != nil && f.whitelist.IsWhitelistError(body, err) {
+		apiUrl, body, err = f.registerAndRetry(ctx, count, apiUrl, body, err)
+	}
+	if err != nil {
+		f.logger.Warn(fmt.Sprintf("[Warehouse] 调用代理供应商API失败. %v", err))
+		f.observer.OnVendorError(&event.VendorErrorEvent{Source: f.url, Url: apiUrl, Body: body, Err: err, Time: time.Now()})
+		return nil, err
+	}
+	rawProxies, invalid, ok := f.parseBody(body)
+	returned = len(rawProxies) + invalid
+	if !ok {
+		f.logger.Warn(fmt.Sprintf("[Warehouse] 供应商API返回非法文本. 原文: %s", body))
+		err = fmt.Errorf("%w. 原文: %s", errInvalidBody, body)
+		f.observer.OnVendorError(&event.VendorErrorEvent{Source: f.url, Url: apiUrl, Body: body, Err: err, Time: time.Now()})
+		return nil, err
+	}
+	proxies = f.formatRawProxies(rawProxies)
+	f.observer.OnFetched(&event.FetchedEvent{Source: f.url, Proxies: proxies, Time: time.Now()})
+	return proxies, nil
+}
+
+// registerAndRetry 登记白名单后重新调用一次API, 登记失败时返回原结果
+//
+// 登记和重试分别重新生成占位符取值并签名, 避免重复使用ts, nonce. 返回实际请求的apiUrl.
+// 重试后仍为白名单错误时, 白名单进入退避, 避免每次获取都重复登记
+func (f *Warehouse) registerAndRetry(ctx context.Context, count int, apiUrl string, body string, err error) (string, string, error) {
+	ip, rErr := f.whitelist.Register(ctx, f.placeholders(count))
+	if rErr != nil {
+		f.logger.Warn(fmt.Sprintf("[Warehouse] 添加IP白名单失败. %v", rErr))
+		return apiUrl, body, err
+	}
+	f.logger.Info(fmt.Sprintf("[Warehouse] 已添加IP白名单. ip=%s", ip))
+	values := f.placeholders(count)
+	apiUrl, err = buildApiUrl(f.url, f.signer, values)
+	if err != nil {
+		return apiUrl, "", err
 	}
-	return strings.ReplaceAll(f.url, `${num}`, strconv.Itoa(count))
+	body, err = f.callApi(ctx, apiUrl, f.renderBody(values))
+	if f.whitelist.IsWhitelistError(body, err) {
+		f.whitelist.fail()
+	} else {
+		f.whitelist.succeed()
+	}
+	return apiUrl, body, err
+}
+
+// parseBody 从返回文本中解析原始代理, invalid为宽松模式下丢弃的非法行数
+//
+// 严格模式下存在非法行即失败; 宽松模式下丢弃非法行, 没有任何合法代理时失败
+func (f *Warehouse) parseBody(body string) (proxies []string, invalid int, ok bool) {
+	if !f.lenient {
+		if !util.IsContainsProxyOnly(body, f.splitter, f.formats...) {
+			return nil, 0, false
+		}
+		return util.GetProxyFromBody(body, f.splitter), 0, true
+	}
+	proxies, lines := util.ParseProxyBody(body, f.splitter, f.formats...)
+	for _, line := range lines {
+		f.logger.Warn(fmt.Sprintf("[Warehouse] 忽略非法代理行. 第%d行: %s, %s", line.Line, line.Text, line.Reason))
+		f.discard([]string{line.Text}, event.DiscardInvalid, line.Reason)
+	}
+	return proxies, len(lines), len(proxies) > 0
 }
 
 // formatRawProxies 格式化原始代理
 func (f *Warehouse) formatRawProxies(proxies []string) []string {
 	var arr []string
 	for _, proxy := range proxies {
-		if p, err := util.FormatRawProxy(proxy, f.username, f.password); err == nil {
-			arr = append(arr, p)
+		p, err := util.FormatRawProxy(proxy, f.username, f.password, f.formats...)
+		if err != nil {
+			f.discard([]string{proxy}, event.DiscardInvalid, err.Error())
+			continue
 		}
+		arr = append(arr, p)
 	}
 	return arr
 }
 
-// callApi
-func (f *Warehouse) callApi(ctx context.Context, apiUrl string) (body string, err error) {
-	req, _ := http.NewRequest("GET", apiUrl, nil)
+// discard 通知observer代理被丢弃
+func (f *Warehouse) discard(proxies []string, reason event.DiscardReason, detail string) {
+	for _, proxy := range proxies {
+		f.observer.OnDiscarded(&event.DiscardedEvent{Proxy: proxy, Reason: reason, Detail: detail, Time: time.Now()})
+	}
+}
+
+// renderBody 替换请求体模板中的占位符, 未配置请求体时返回空
+func (f *Warehouse) renderBody(values map[string]string) string {
+	if f.body == "" {
+		return ""
+	}
+	var escape func(string) string
+	if !isJsonBody(f.body) {
+		escape = url.QueryEscape
+	}
+	return render(f.body, values, escape)
+}
+
+// newRequest 按配置的请求方法和请求头创建API请求, body为渲染后的请求体
+func (f *Warehouse) newRequest(ctx context.Context, apiUrl string, body string) (*http.Request, error) {
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(f.method, apiUrl, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("创建代理API请求失败. %+v", err)
+	}
 	if ctx != nil {
 		req = req.WithContext(ctx)
 	}
+	for k, v := range f.header {
+		req.Header[k] = v
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		if isJsonBody(f.body) {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	return req, nil
+}
+
+// isJsonBody 请求体模板是否为JSON, 否则视为表单
+func isJsonBody(body string) bool {
+	return strings.HasPrefix(strings.TrimSpace(body), "{")
+}
+
+// callApi
+func (f *Warehouse) callApi(ctx context.Context, apiUrl string, reqBody string) (body string, err error) {
+	req, err := f.newRequest(ctx, apiUrl, reqBody)
+	if err != nil {
+		return "", err
+	}
 	resp, err := f.client.Do(req)
 	if err != nil {
 		return "", err
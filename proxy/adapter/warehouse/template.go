@@ -0,0 +1,145 @@
+package warehouse
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Signer 为每次API调用计算签名参数
+type Signer interface {
+	// Sign query为占位符替换后的请求参数, 签名参数直接写入query
+	Sign(query url.Values) error
+}
+
+// SignerFunc 以函数形式实现Signer
+type SignerFunc func(query url.Values) error
+
+func (f SignerFunc) Sign(query url.Values) error {
+	return f(query)
+}
+
+// Md5Signer 签名为hex(MD5(按参数名排序的k1=v1&k2=v2 + Secret))
+type Md5Signer struct {
+	Secret string
+	// Param 签名参数名, 默认sign
+	Param string
+	// Upper 签名是否转为大写
+	Upper bool
+}
+
+func (s *Md5Signer) Sign(query url.Values) error {
+	sum := md5.Sum([]byte(signContent(query, s.Param) + s.Secret))
+	sign := hex.EncodeToString(sum[:])
+	if s.Upper {
+		sign = strings.ToUpper(sign)
+	}
+	query.Set(signParam(s.Param), sign)
+	return nil
+}
+
+// HmacSigner 签名为hex(HMAC-SHA256(Secret, 按参数名排序的k1=v1&k2=v2))
+type HmacSigner struct {
+	Secret string
+	// Param 签名参数名, 默认sign
+	Param string
+}
+
+func (s *HmacSigner) Sign(query url.Values) error {
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write([]byte(signContent(query, s.Param)))
+	query.Set(signParam(s.Param), hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func signParam(param string) string {
+	if param == "" {
+		return "sign"
+	}
+	return param
+}
+
+// signContent 待签名文本, 不编码参数值, 排除签名参数本身
+func signContent(query url.Values, param string) string {
+	q := url.Values{}
+	for k, v := range query {
+		if k != signParam(param) {
+			q[k] = v
+		}
+	}
+	content, _ := url.QueryUnescape(q.Encode())
+	return content
+}
+
+// placeholders 生成一次API调用使用的占位符取值, 同一次调用中${ts}和${nonce}保持一致
+func (f *Warehouse) placeholders(count int) map[string]string {
+	return map[string]string{
+		"num":      strconv.Itoa(count),
+		"ts":       strconv.FormatInt(f.now().Unix(), 10),
+		"nonce":    newNonce(),
+		"country":  f.country,
+		"protocol": f.protocol,
+	}
+}
+
+// render 从左到右替换模板中的${name}占位符, 未知占位符保持原样, 替换后的值不会被再次替换
+//
+// escape不为空时对替换的值编码
+func render(tmpl string, values map[string]string, escape func(string) string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(tmpl, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(tmpl[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+		v, ok := values[tmpl[start+2:end]]
+		if !ok {
+			b.WriteString(tmpl[:end+1])
+			tmpl = tmpl[end+1:]
+			continue
+		}
+		if escape != nil {
+			v = escape(v)
+		}
+		b.WriteString(tmpl[:start])
+		b.WriteString(v)
+		tmpl = tmpl[end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// buildApiUrl 替换rawUrl中的占位符, signer不为空时签名, 生成实际调用的url
+func buildApiUrl(rawUrl string, signer Signer, values map[string]string) (string, error) {
+	apiUrl := render(rawUrl, values, url.QueryEscape)
+	if signer == nil {
+		return apiUrl, nil
+	}
+	parsed, err := url.Parse(apiUrl)
+	if err != nil {
+		return "", fmt.Errorf("代理API地址格式错误. %+v", err)
+	}
+	query := parsed.Query()
+	if err := signer.Sign(query); err != nil {
+		return "", fmt.Errorf("代理API签名失败. %+v", err)
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+func newNonce() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,95 @@
+package warehouse
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildApiUrl(t *testing.T) {
+	convey.Convey("buildApiUrl", t, func() {
+		w := NewWarehouse(&CreateConfig{
+			Url:      "http://localhost?qty=${num}&ts=${ts}&nonce=${nonce}&country=${country}&protocol=${protocol}",
+			Country:  "US",
+			Protocol: "socks5",
+		})
+		w.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+		convey.Convey("Replace all placeholders", func() {
+			values := w.placeholders(5)
+			apiUrl, err := buildApiUrl(w.url, w.signer, values)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(apiUrl, convey.ShouldEqual, "http://localhost?qty=5&ts=1700000000&nonce="+values["nonce"]+"&country=US&protocol=socks5")
+			convey.So(len(values["nonce"]), convey.ShouldEqual, 16)
+			convey.So(w.placeholders(5)["nonce"], convey.ShouldNotEqual, values["nonce"])
+		})
+
+		convey.Convey("ApiUrl contains ${num} only", func() {
+			w.url = "http://localhost?qty=${num}&type="
+			apiUrl, _ := buildApiUrl(w.url, w.signer, w.placeholders(5))
+			convey.So(apiUrl, convey.ShouldEqual, "http://localhost?qty=5&type=")
+		})
+
+		convey.Convey("ApiUrl without placeholders", func() {
+			w.url = "http://localhost?qty=1&type="
+			apiUrl, _ := buildApiUrl(w.url, w.signer, w.placeholders(5))
+			convey.So(apiUrl, convey.ShouldEqual, "http://localhost?qty=1&type=")
+		})
+
+		convey.Convey("Values are query escaped", func() {
+			w.url = "http://localhost?country=${country}&type=1"
+			w.country = "US&type=2 x"
+			apiUrl, _ := buildApiUrl(w.url, w.signer, w.placeholders(5))
+			convey.So(apiUrl, convey.ShouldEqual, "http://localhost?country=US%26type%3D2+x&type=1")
+		})
+
+		convey.Convey("Unknown placeholders are kept", func() {
+			convey.So(render("http://localhost?a=${unknown}", w.placeholders(1), nil), convey.ShouldEqual, "http://localhost?a=${unknown}")
+		})
+
+		convey.Convey("Replaced values are not rendered again", func() {
+			values := map[string]string{"country": "${num}", "num": "5"}
+			convey.So(render("${country}-${num}-${", values, nil), convey.ShouldEqual, "${num}-5-${")
+		})
+
+		convey.Convey("Signer adds signature parameters", func() {
+			w.url = "http://localhost/get?qty=${num}&ts=${ts}"
+			w.signer = SignerFunc(func(query url.Values) error {
+				query.Set("sign", query.Get("qty")+query.Get("ts"))
+				return nil
+			})
+			apiUrl, err := buildApiUrl(w.url, w.signer, w.placeholders(3))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(apiUrl, convey.ShouldEqual, "http://localhost/get?qty=3&sign=31700000000&ts=1700000000")
+		})
+	})
+}
+
+func TestSigner(t *testing.T) {
+	convey.Convey("Signer", t, func() {
+		query := func() url.Values {
+			return url.Values{"b": []string{"2"}, "a": []string{"1"}, "sign": []string{"old"}}
+		}
+
+		convey.Convey("Md5Signer", func() {
+			q := query()
+			convey.So((&Md5Signer{Secret: "s", Upper: true}).Sign(q), convey.ShouldBeNil)
+			sum := md5.Sum([]byte("a=1&b=2s"))
+			convey.So(q.Get("sign"), convey.ShouldEqual, strings.ToUpper(hex.EncodeToString(sum[:])))
+		})
+
+		convey.Convey("HmacSigner", func() {
+			q := query()
+			convey.So((&HmacSigner{Secret: "s", Param: "signature"}).Sign(q), convey.ShouldBeNil)
+			mac := hmac.New(sha256.New, []byte("s"))
+			mac.Write([]byte("a=1&b=2&sign=old"))
+			convey.So(q.Get("signature"), convey.ShouldEqual, hex.EncodeToString(mac.Sum(nil)))
+		})
+	})
+}
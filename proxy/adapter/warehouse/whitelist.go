@@ -0,0 +1,238 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/util"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WhitelistConfig IP白名单自动登记配置
+type WhitelistConfig struct {
+	// AddUrl 供应商添加白名单接口, 支持占位符${ip}以及Url中的占位符
+	AddUrl string
+	// Signer 对AddUrl签名, 为空时不签名
+	Signer Signer
+	// IpEchoUrl 获取本机出口IP的接口, 使用Client请求, 默认http://httpbin.org/ip
+	IpEchoUrl string
+	// Keywords API返回文本包含任一关键字时视为白名单错误, 默认"白名单", "whitelist"
+	Keywords []string
+	// Detect 自定义白名单错误判断, 设置时忽略Keywords
+	Detect func(body string, err error) bool
+	// Client 请求添加白名单接口及IpEchoUrl使用的http.Client, 默认超时5秒
+	Client *http.Client
+	// Backoff 登记失败或登记后仍返回白名单错误时, 下次登记前的等待时间, 默认1秒, 每次翻倍, 最长5分钟
+	Backoff time.Duration
+}
+
+// maxWhitelistBackoff 登记退避的最长时间
+const maxWhitelistBackoff = 5 * time.Minute
+
+// Whitelist 检测白名单错误, 并将本机出口IP登记到供应商白名单
+type Whitelist struct {
+	addUrl    string
+	signer    Signer
+	ipEchoUrl string
+	keywords  []string
+	detect    func(body string, err error) bool
+	client    *http.Client
+	backoff   time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	ip string
+	// stale 登记后API仍返回白名单错误, 退避结束后重新调用添加接口
+	stale    bool
+	failures int
+	next     time.Time
+	// pending 正在登记时不为空, 登记期间不持有mu
+	pending *registration
+}
+
+// registration 一次进行中的登记, done关闭后ip, err可读
+type registration struct {
+	done chan struct{}
+	ip   string
+	err  error
+}
+
+// NewWhitelist 创建Whitelist
+func NewWhitelist(config *WhitelistConfig) *Whitelist {
+	ipEchoUrl := config.IpEchoUrl
+	if ipEchoUrl == "" {
+		ipEchoUrl = "http://httpbin.org/ip"
+	}
+	keywords := config.Keywords
+	if len(keywords) == 0 {
+		keywords = []string{"白名单", "whitelist"}
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	backoff := config.Backoff
+	if backoff <= 0 {
+		backoff = 1 * time.Second
+	}
+	return &Whitelist{
+		addUrl:    config.AddUrl,
+		signer:    config.Signer,
+		ipEchoUrl: ipEchoUrl,
+		keywords:  keywords,
+		detect:    config.Detect,
+		client:    client,
+		backoff:   backoff,
+		now:       time.Now,
+	}
+}
+
+// IsWhitelistError 判断API调用结果是否为白名单错误
+func (w *Whitelist) IsWhitelistError(body string, err error) bool {
+	if w.detect != nil {
+		return w.detect(body, err)
+	}
+	text := strings.ToLower(body)
+	if err != nil {
+		text += strings.ToLower(err.Error())
+	}
+	for _, keyword := range w.keywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Register 获取本机出口IP并登记到供应商白名单
+//
+// values 为Url占位符取值, 额外提供${ip}
+//
+// 出口IP已登记过且未失效时不再调用添加接口. 登记失败后进入退避, 退避期间直接返回错误.
+// 同一时间只有一次登记, 并发调用等待其结果
+func (w *Whitelist) Register(ctx context.Context, values map[string]string) (ip string, err error) {
+	w.mu.Lock()
+	if r := w.pending; r != nil {
+		w.mu.Unlock()
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+		select {
+		case <-r.done:
+			return r.ip, r.err
+		case <-done:
+			return "", ctx.Err()
+		}
+	}
+	if now := w.now(); now.Before(w.next) {
+		w.mu.Unlock()
+		return "", fmt.Errorf("白名单登记退避中, %s后重试", w.next.Sub(now))
+	}
+	r := &registration{done: make(chan struct{})}
+	w.pending = r
+	registered := w.ip
+	if w.stale {
+		registered = ""
+	}
+	w.mu.Unlock()
+
+	r.ip, r.err = w.register(ctx, registered, values)
+
+	w.mu.Lock()
+	if r.err != nil {
+		w.failLocked()
+	} else {
+		w.ip = r.ip
+		w.stale = false
+	}
+	w.pending = nil
+	w.mu.Unlock()
+	close(r.done)
+	return r.ip, r.err
+}
+
+// register 获取出口IP, 与registered不同时调用添加接口. 不持有mu
+func (w *Whitelist) register(ctx context.Context, registered string, values map[string]string) (string, error) {
+	ip, err := util.GetPublicIpWithClient(ctx, w.client, w.ipEchoUrl)
+	if err != nil {
+		return "", fmt.Errorf("获取本机出口IP失败. %v", err)
+	}
+	if ip == registered {
+		return ip, nil
+	}
+	if err := w.add(ctx, ip, values); err != nil {
+		return "", err
+	}
+	return ip, nil
+}
+
+// add 调用添加白名单接口
+func (w *Whitelist) add(ctx context.Context, ip string, values map[string]string) error {
+	params := map[string]string{"ip": ip}
+	for k, v := range values {
+		params[k] = v
+	}
+	addUrl, err := buildApiUrl(w.addUrl, w.signer, params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, addUrl, nil)
+	if err != nil {
+		return fmt.Errorf("创建添加白名单请求失败. %+v", err)
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	buf, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return errors.New("调用添加白名单API失败")
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("调用添加白名单API返回状态码异常, StatusCode=%d, 原文: %s", resp.StatusCode, buf)
+	}
+	return nil
+}
+
+// fail 登记后API仍返回白名单错误, 进入退避, 并视已登记的IP为失效
+func (w *Whitelist) fail() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stale = true
+	w.failLocked()
+}
+
+// failLocked 按失败次数计算下次可以登记的时间, 需持有mu
+func (w *Whitelist) failLocked() {
+	backoff := w.backoff << w.failures
+	if backoff <= 0 || backoff > maxWhitelistBackoff {
+		backoff = maxWhitelistBackoff
+	} else {
+		w.failures++
+	}
+	w.next = w.now().Add(backoff)
+}
+
+// succeed 登记后API调用成功, 清除退避
+func (w *Whitelist) succeed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures = 0
+	w.next = time.Time{}
+}
+
+// Ip 最近一次登记的IP
+func (w *Whitelist) Ip() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ip
+}
@@ -0,0 +1,164 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWhitelist(t *testing.T) {
+	convey.Convey("Whitelist", t, func() {
+		var whitelisted string
+		var added, echoed int32
+		var nonces []string
+		var slow chan struct{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if nonce := r.URL.Query().Get("nonce"); nonce != "" {
+				nonces = append(nonces, nonce)
+			}
+			switch r.URL.Path {
+			case "/ip":
+				atomic.AddInt32(&echoed, 1)
+				if slow != nil {
+					<-slow
+				}
+				_, _ = io.WriteString(w, `{"origin": "1.2.3.4"}`)
+			case "/add":
+				atomic.AddInt32(&added, 1)
+				whitelisted = r.URL.Query().Get("ip")
+			case "/denied":
+				_, _ = io.WriteString(w, `{"code":113,"msg":"请添加白名单"}`)
+			default:
+				if whitelisted == "" {
+					_, _ = io.WriteString(w, `{"code":113,"msg":"请添加白名单"}`)
+					return
+				}
+				_, _ = io.WriteString(w, "192.168.70.1:8888")
+			}
+		}))
+		defer server.Close()
+
+		convey.Convey("Detect whitelist error by keywords", func() {
+			w := NewWhitelist(&WhitelistConfig{})
+			convey.So(w.IsWhitelistError(`{"msg":"IP not in Whitelist"}`, nil), convey.ShouldBeTrue)
+			convey.So(w.IsWhitelistError("", errors.New("请添加白名单")), convey.ShouldBeTrue)
+			convey.So(w.IsWhitelistError(`{"msg":"余额不足"}`, nil), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Register and retry the fetch", func() {
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}&ts=${ts}", IpEchoUrl: server.URL + "/ip"})
+			fetcher := NewWarehouse(&CreateConfig{Url: server.URL + "/get?qty=${num}", Whitelist: w})
+			proxies, err := fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(proxies, convey.ShouldResemble, []string{"http://192.168.70.1:8888"})
+			convey.So(whitelisted, convey.ShouldEqual, "1.2.3.4")
+			convey.So(w.Ip(), convey.ShouldEqual, "1.2.3.4")
+		})
+
+		convey.Convey("Register and retry with fresh placeholders", func() {
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}&nonce=${nonce}", IpEchoUrl: server.URL + "/ip"})
+			fetcher := NewWarehouse(&CreateConfig{Url: server.URL + "/get?qty=${num}&nonce=${nonce}", Whitelist: w})
+			_, err := fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(err, convey.ShouldBeNil)
+			// 首次调用, 添加白名单, 重试各自使用新的nonce
+			convey.So(len(nonces), convey.ShouldEqual, 3)
+			convey.So(nonces[1], convey.ShouldNotEqual, nonces[0])
+			convey.So(nonces[2], convey.ShouldNotEqual, nonces[0])
+			convey.So(nonces[2], convey.ShouldNotEqual, nonces[1])
+		})
+
+		convey.Convey("Concurrent registrations share one lookup", func() {
+			slow = make(chan struct{})
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}", IpEchoUrl: server.URL + "/ip"})
+			var wg sync.WaitGroup
+			ips := make([]string, 3)
+			for i := range ips {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					ips[i], _ = w.Register(context.TODO(), nil)
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			// 登记期间不持有锁
+			convey.So(w.Ip(), convey.ShouldEqual, "")
+			close(slow)
+			wg.Wait()
+			convey.So(ips, convey.ShouldResemble, []string{"1.2.3.4", "1.2.3.4", "1.2.3.4"})
+			convey.So(atomic.LoadInt32(&echoed), convey.ShouldEqual, 1)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Look up the exit ip with the configured client", func() {
+			var requested int32
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&requested, 1)
+				return http.DefaultTransport.RoundTrip(req)
+			})}
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}", IpEchoUrl: server.URL + "/ip", Client: client})
+			ip, err := w.Register(context.TODO(), nil)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(ip, convey.ShouldEqual, "1.2.3.4")
+			convey.So(atomic.LoadInt32(&requested), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Register once per exit ip", func() {
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}", IpEchoUrl: server.URL + "/ip"})
+			ip, err := w.Register(context.TODO(), nil)
+			convey.So(err, convey.ShouldBeNil)
+			ip, err = w.Register(context.TODO(), nil)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(ip, convey.ShouldEqual, "1.2.3.4")
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Back off when the api still rejects after registration", func() {
+			now := time.Unix(1700000000, 0)
+			w := NewWhitelist(&WhitelistConfig{AddUrl: server.URL + "/add?ip=${ip}", IpEchoUrl: server.URL + "/ip"})
+			w.now = func() time.Time { return now }
+			fetcher := NewWarehouse(&CreateConfig{Url: server.URL + "/denied", Whitelist: w})
+
+			_, err := fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 1)
+
+			_, err = fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 1)
+
+			// 退避结束后重新登记, 下次退避时间翻倍
+			now = now.Add(1 * time.Second)
+			_, _ = fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 2)
+			now = now.Add(1 * time.Second)
+			_, _ = fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 2)
+			now = now.Add(1 * time.Second)
+			_, _ = fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(atomic.LoadInt32(&added), convey.ShouldEqual, 3)
+		})
+
+		convey.Convey("Fail with the original error when registration fails", func() {
+			w := NewWhitelist(&WhitelistConfig{AddUrl: "http://127.0.0.1:1/add?ip=${ip}", IpEchoUrl: server.URL + "/ip"})
+			fetcher := NewWarehouse(&CreateConfig{Url: server.URL + "/get?qty=${num}", Whitelist: w})
+			_, err := fetcher.GetProxiesSync(context.TODO(), 1, true)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "白名单")
+			convey.So(whitelisted, convey.ShouldEqual, "")
+		})
+	})
+}
+
+// roundTripFunc 以函数形式实现http.RoundTripper
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,220 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrExceeded 预算已用完, 可以用errors.Is判断. 具体信息通过errors.As获取*ExceededError
+var ErrExceeded = errors.New("代理预算已用完")
+
+// ExceededError 预算超限错误
+type ExceededError struct {
+	// Window 超限的统计窗口, hourly或daily
+	Window string
+	Limit  int
+	Used   int
+	// Reset 预算恢复时间
+	Reset time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("代理预算已用完. window=%s, used=%d, limit=%d, reset=%s", e.Window, e.Used, e.Limit, e.Reset.Format(time.RFC3339))
+}
+
+func (e *ExceededError) Is(target error) bool {
+	return target == ErrExceeded
+}
+
+// Budget 统计单个供应商获取的代理数量并限制每小时, 每天的预算
+//
+// 统计窗口为自然小时和自然日. 每个adapter使用各自的Budget.
+type Budget struct {
+	hourly       int
+	daily        int
+	costPerProxy float64
+	block        bool
+	location     *time.Location
+	now          func() time.Time
+	wait         func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	hours map[time.Time]int
+	total int
+}
+
+type Config struct {
+	// Hourly 每小时最多获取的代理数量, 0为不限制
+	Hourly int
+	// Daily 每天最多获取的代理数量, 0为不限制
+	Daily int
+	// CostPerProxy 每个代理的价格, 用于统计花费
+	CostPerProxy float64
+	// Block 预算用完时阻塞等待预算恢复, 为false时返回*ExceededError
+	Block bool
+	// Location 划分自然日使用的时区, 默认time.Local
+	Location *time.Location
+}
+
+// Usage 当前用量
+type Usage struct {
+	Hour        int
+	Day         int
+	Total       int
+	HourCost    float64
+	DayCost     float64
+	TotalCost   float64
+	HourlyLimit int
+	DailyLimit  int
+}
+
+// NewBudget 创建Budget
+func NewBudget(config *Config) *Budget {
+	location := config.Location
+	if location == nil {
+		location = time.Local
+	}
+	return &Budget{
+		hourly:       config.Hourly,
+		daily:        config.Daily,
+		costPerProxy: config.CostPerProxy,
+		block:        config.Block,
+		location:     location,
+		now:          time.Now,
+		wait:         sleep,
+		hours:        map[time.Time]int{},
+	}
+}
+
+// Reserve 预留count个代理的预算, 返回实际可获取的数量
+//
+// 剩余预算不足count时只预留剩余部分. 预算用完时按Block阻塞或返回*ExceededError.
+// 获取完成后必须调用commit提交实际获取的数量, 获取失败时提交0
+func (b *Budget) Reserve(ctx context.Context, count int) (granted int, commit func(used int), err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		b.mu.Lock()
+		now := b.now().In(b.location)
+		remaining, exceeded := b.remaining(now)
+		if exceeded == nil {
+			if count > remaining {
+				count = remaining
+			}
+			hour := hourStart(now)
+			b.hours[hour] += count
+			b.total += count
+			b.mu.Unlock()
+			return count, b.committer(hour, count), nil
+		}
+		b.mu.Unlock()
+		if !b.block {
+			return 0, nil, exceeded
+		}
+		if err := b.wait(ctx, exceeded.Reset.Sub(now)); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// Usage 返回当前用量
+func (b *Budget) Usage() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now().In(b.location)
+	hour, day := b.hours[hourStart(now)], b.dayUsed(now)
+	return Usage{
+		Hour:        hour,
+		Day:         day,
+		Total:       b.total,
+		HourCost:    float64(hour) * b.costPerProxy,
+		DayCost:     float64(day) * b.costPerProxy,
+		TotalCost:   float64(b.total) * b.costPerProxy,
+		HourlyLimit: b.hourly,
+		DailyLimit:  b.daily,
+	}
+}
+
+// committer 返回提交函数, 按实际数量修正预留的用量. 重复调用无效
+func (b *Budget) committer(hour time.Time, reserved int) func(used int) {
+	var once sync.Once
+	return func(used int) {
+		once.Do(func() {
+			if used < 0 {
+				used = 0
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.hours[hour] += used - reserved
+			b.total += used - reserved
+		})
+	}
+}
+
+// remaining 返回剩余预算, 用完时返回超限错误. 需持有mu
+func (b *Budget) remaining(now time.Time) (int, *ExceededError) {
+	b.prune(now)
+	remaining := int(^uint(0) >> 1)
+	if b.daily > 0 {
+		used := b.dayUsed(now)
+		if used >= b.daily {
+			return 0, &ExceededError{Window: "daily", Limit: b.daily, Used: used, Reset: dayStart(now).AddDate(0, 0, 1)}
+		}
+		remaining = b.daily - used
+	}
+	if b.hourly > 0 {
+		used := b.hours[hourStart(now)]
+		if used >= b.hourly {
+			return 0, &ExceededError{Window: "hourly", Limit: b.hourly, Used: used, Reset: hourStart(now).Add(time.Hour)}
+		}
+		if b.hourly-used < remaining {
+			remaining = b.hourly - used
+		}
+	}
+	return remaining, nil
+}
+
+// dayUsed 当天用量. 需持有mu
+func (b *Budget) dayUsed(now time.Time) int {
+	start := dayStart(now)
+	var used int
+	for hour, n := range b.hours {
+		if !hour.Before(start) {
+			used += n
+		}
+	}
+	return used
+}
+
+// prune 清除前一天之前的统计. 需持有mu
+func (b *Budget) prune(now time.Time) {
+	start := dayStart(now).AddDate(0, 0, -1)
+	for hour := range b.hours {
+		if hour.Before(start) {
+			delete(b.hours, hour)
+		}
+	}
+}
+
+func hourStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestBudget_Reserve(t *testing.T) {
+	convey.Convey("Reserve", t, func() {
+		now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+		newBudget := func(config *Config) *Budget {
+			config.Location = time.UTC
+			b := NewBudget(config)
+			b.now = func() time.Time { return now }
+			return b
+		}
+
+		convey.Convey("Grant the remaining hourly budget and fail once exceeded", func() {
+			b := newBudget(&Config{Hourly: 5, CostPerProxy: 0.5})
+			granted, commit, err := b.Reserve(context.TODO(), 3)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(granted, convey.ShouldEqual, 3)
+			commit(3)
+			granted, commit, _ = b.Reserve(context.TODO(), 3)
+			convey.So(granted, convey.ShouldEqual, 2)
+			commit(2)
+
+			_, _, err = b.Reserve(context.TODO(), 1)
+			convey.So(errors.Is(err, ErrExceeded), convey.ShouldBeTrue)
+			var exceeded *ExceededError
+			convey.So(errors.As(err, &exceeded), convey.ShouldBeTrue)
+			convey.So(exceeded.Window, convey.ShouldEqual, "hourly")
+			convey.So(exceeded.Reset, convey.ShouldEqual, time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC))
+
+			usage := b.Usage()
+			convey.So(usage.Hour, convey.ShouldEqual, 5)
+			convey.So(usage.HourCost, convey.ShouldEqual, 2.5)
+
+			now = now.Add(time.Hour)
+			granted, _, err = b.Reserve(context.TODO(), 1)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(granted, convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Commit corrects the reservation", func() {
+			b := newBudget(&Config{Daily: 10})
+			_, commit, _ := b.Reserve(context.TODO(), 5)
+			convey.So(b.Usage().Day, convey.ShouldEqual, 5)
+			commit(0)
+			commit(5)
+			convey.So(b.Usage().Day, convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Daily budget spans hours", func() {
+			b := newBudget(&Config{Hourly: 5, Daily: 8})
+			_, commit, _ := b.Reserve(context.TODO(), 5)
+			commit(5)
+			now = now.Add(time.Hour)
+			granted, commit, _ := b.Reserve(context.TODO(), 5)
+			convey.So(granted, convey.ShouldEqual, 3)
+			commit(3)
+			var exceeded *ExceededError
+			_, _, err := b.Reserve(context.TODO(), 1)
+			convey.So(errors.As(err, &exceeded), convey.ShouldBeTrue)
+			convey.So(exceeded.Window, convey.ShouldEqual, "daily")
+			convey.So(exceeded.Reset, convey.ShouldEqual, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+
+			now = exceeded.Reset
+			usage := b.Usage()
+			convey.So(usage.Day, convey.ShouldEqual, 0)
+			convey.So(usage.Total, convey.ShouldEqual, 8)
+		})
+
+		convey.Convey("Block until the budget resets", func() {
+			b := newBudget(&Config{Hourly: 1, Block: true})
+			var waited time.Duration
+			b.wait = func(ctx context.Context, d time.Duration) error {
+				waited = d
+				now = now.Add(d)
+				return nil
+			}
+			_, commit, _ := b.Reserve(context.TODO(), 1)
+			commit(1)
+			granted, _, err := b.Reserve(context.TODO(), 1)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(granted, convey.ShouldEqual, 1)
+			convey.So(waited, convey.ShouldEqual, 30*time.Minute)
+		})
+
+		convey.Convey("Blocking honors ctx", func() {
+			b := newBudget(&Config{Hourly: 1, Block: true})
+			_, commit, _ := b.Reserve(context.TODO(), 1)
+			commit(1)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, _, err := b.Reserve(ctx, 1)
+			convey.So(err, convey.ShouldEqual, context.Canceled)
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package cooldown
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracker 按目标域名记录代理的使用与封禁
+//
+// 代理用于某个域名后, 在Cooldown内不再分配给该域名; 被该域名封禁后, 在BlockDuration内不再分配给该域名.
+// 两种情况下代理对其他域名仍然可用.
+type Tracker struct {
+	cooldown      time.Duration
+	blockDuration time.Duration
+	now           func() time.Time
+
+	mu        sync.Mutex
+	entries   map[key]*entry
+	lastPrune time.Time
+}
+
+type key struct {
+	domain string
+	proxy  string
+}
+
+type entry struct {
+	until   time.Time
+	blocked bool
+}
+
+type Config struct {
+	// Cooldown 代理用于同一域名的最小间隔, 默认10秒
+	Cooldown time.Duration
+	// BlockDuration 代理被域名封禁后的冷却时间, 默认30分钟
+	BlockDuration time.Duration
+}
+
+// NewTracker 创建Tracker, config为空时使用默认配置
+func NewTracker(config *Config) *Tracker {
+	if config == nil {
+		config = &Config{}
+	}
+	cooldown := config.Cooldown
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+	blockDuration := config.BlockDuration
+	if blockDuration <= 0 {
+		blockDuration = 30 * time.Minute
+	}
+	return &Tracker{
+		cooldown:      cooldown,
+		blockDuration: blockDuration,
+		now:           time.Now,
+		entries:       map[key]*entry{},
+	}
+}
+
+// Domain 规范化域名, 去除端口并转为小写
+func Domain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Available 返回proxies中可以用于domain的代理
+//
+// 每隔Cooldown顺带清除一次过期记录
+func (t *Tracker) Available(domain string, proxies []string) []string {
+	domain = Domain(domain)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if now.Sub(t.lastPrune) >= t.cooldown {
+		t.pruneLocked(now)
+	}
+	var available []string
+	for _, proxy := range proxies {
+		if e, ok := t.entries[key{domain, proxy}]; ok && now.Before(e.until) {
+			continue
+		}
+		available = append(available, proxy)
+	}
+	return available
+}
+
+// WaitTime 返回proxies中最早可以用于domain的代理还需等待的时间, 已有可用代理或proxies为空时返回0
+func (t *Tracker) WaitTime(domain string, proxies []string) time.Duration {
+	domain = Domain(domain)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var wait time.Duration
+	for i, proxy := range proxies {
+		e, ok := t.entries[key{domain, proxy}]
+		if !ok || !now.Before(e.until) {
+			return 0
+		}
+		if d := e.until.Sub(now); i == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Use 记录proxy用于domain, 开始冷却. 不会缩短封禁时间
+func (t *Tracker) Use(domain string, proxy string) {
+	t.set(domain, proxy, t.cooldown, false)
+}
+
+// Cancel 撤销请求未发出时的Use, 封禁记录不受影响
+//
+// 代理只有在没有未过期记录时才会被选中, 因此删除冷却记录即可恢复Use之前的状态
+func (t *Tracker) Cancel(domain string, proxy string) {
+	k := key{Domain(domain), proxy}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.entries[k]; ok && !e.blocked {
+		delete(t.entries, k)
+	}
+}
+
+// Block 记录proxy被domain封禁
+func (t *Tracker) Block(domain string, proxy string) {
+	t.set(domain, proxy, t.blockDuration, true)
+}
+
+func (t *Tracker) set(domain string, proxy string, d time.Duration, blocked bool) {
+	k := key{Domain(domain), proxy}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until := t.now().Add(d)
+	if e, ok := t.entries[k]; ok && e.until.After(until) {
+		return
+	}
+	t.entries[k] = &entry{until: until, blocked: blocked}
+}
+
+// Blocked proxy当前是否被domain封禁
+func (t *Tracker) Blocked(domain string, proxy string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key{Domain(domain), proxy}]
+	return ok && e.blocked && t.now().Before(e.until)
+}
+
+// Forget 清除proxy在所有域名下的记录, 代理被丢弃时调用
+func (t *Tracker) Forget(proxy string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.entries {
+		if k.proxy == proxy {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// Prune 清除已过期的记录, 返回清除数量
+func (t *Tracker) Prune() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pruneLocked(t.now())
+}
+
+// pruneLocked 清除now时已过期的记录, 需持有mu
+func (t *Tracker) pruneLocked(now time.Time) int {
+	t.lastPrune = now
+	var n int
+	for k, e := range t.entries {
+		if !now.Before(e.until) {
+			delete(t.entries, k)
+			n++
+		}
+	}
+	return n
+}
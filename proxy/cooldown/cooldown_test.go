@@ -0,0 +1,88 @@
+package cooldown
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	convey.Convey("Tracker", t, func() {
+		tracker := NewTracker(&Config{Cooldown: 10 * time.Second, BlockDuration: time.Minute})
+		now := time.Now()
+		tracker.now = func() time.Time { return now }
+		proxies := []string{"p1", "p2"}
+
+		convey.Convey("Used proxies cool down for the same domain only", func() {
+			tracker.Use("Example.com:443", "p1")
+			convey.So(tracker.Available("example.com", proxies), convey.ShouldResemble, []string{"p2"})
+			convey.So(tracker.Available("other.com", proxies), convey.ShouldResemble, proxies)
+
+			now = now.Add(10 * time.Second)
+			convey.So(tracker.Available("example.com", proxies), convey.ShouldResemble, proxies)
+		})
+
+		convey.Convey("Blocked proxies stay unavailable longer", func() {
+			tracker.Block("example.com", "p1")
+			convey.So(tracker.Blocked("example.com", "p1"), convey.ShouldBeTrue)
+			convey.So(tracker.Blocked("other.com", "p1"), convey.ShouldBeFalse)
+
+			// 封禁期间使用不会缩短冷却时间
+			tracker.Use("example.com", "p1")
+			now = now.Add(30 * time.Second)
+			convey.So(tracker.Available("example.com", proxies), convey.ShouldResemble, []string{"p2"})
+
+			now = now.Add(30 * time.Second)
+			convey.So(tracker.Blocked("example.com", "p1"), convey.ShouldBeFalse)
+			convey.So(tracker.Available("example.com", proxies), convey.ShouldResemble, proxies)
+		})
+
+		convey.Convey("WaitTime until the earliest proxy cools down", func() {
+			convey.So(tracker.WaitTime("example.com", proxies), convey.ShouldEqual, 0)
+			tracker.Block("example.com", "p1")
+			convey.So(tracker.WaitTime("example.com", proxies), convey.ShouldEqual, 0)
+			tracker.Use("example.com", "p2")
+			convey.So(tracker.WaitTime("example.com", proxies), convey.ShouldEqual, 10*time.Second)
+
+			now = now.Add(4 * time.Second)
+			convey.So(tracker.WaitTime("example.com", proxies), convey.ShouldEqual, 6*time.Second)
+			convey.So(tracker.WaitTime("example.com", nil), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Cancel undoes use but not block", func() {
+			tracker.Use("a.com", "p1")
+			tracker.Cancel("a.com", "p1")
+			convey.So(tracker.Available("a.com", proxies), convey.ShouldResemble, proxies)
+
+			tracker.Block("a.com", "p1")
+			tracker.Cancel("a.com", "p1")
+			convey.So(tracker.Blocked("a.com", "p1"), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Forget and Prune", func() {
+			tracker.Use("a.com", "p1")
+			tracker.Use("b.com", "p1")
+			tracker.Use("a.com", "p2")
+			tracker.Forget("p1")
+			convey.So(tracker.Available("b.com", proxies), convey.ShouldResemble, proxies)
+			convey.So(tracker.Available("a.com", proxies), convey.ShouldResemble, []string{"p1"})
+
+			now = now.Add(time.Minute)
+			convey.So(tracker.Prune(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Available prunes expired entries", func() {
+			tracker.Use("a.com", "p1")
+			tracker.Use("b.com", "p1")
+			now = now.Add(10 * time.Second)
+			tracker.Available("c.com", proxies)
+			convey.So(len(tracker.entries), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Nil config uses defaults", func() {
+			tracker := NewTracker(nil)
+			tracker.Use("a.com", "p1")
+			convey.So(tracker.Available("a.com", proxies), convey.ShouldResemble, []string{"p2"})
+		})
+	})
+}
@@ -0,0 +1,209 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/logger"
+	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/util"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Monitor 定期重新检查持有的代理, 连续失败达到上限的代理会被移除
+type Monitor struct {
+	checker     *util.Checker
+	interval    time.Duration
+	jitter      time.Duration
+	maxFailures int
+	observer    event.Observer
+	logger      logger.Logger
+
+	mu      sync.Mutex
+	proxies map[string]*state
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+type state struct {
+	failures int
+	result   *util.CheckResult
+}
+
+type Config struct {
+	// Checker 检查器, 为空时只检查连通性
+	Checker *util.Checker
+	// Interval 检查间隔, 默认1分钟
+	Interval time.Duration
+	// Jitter 每轮检查额外等待[0, Jitter)的随机时间, 避免多个Monitor同时检查
+	Jitter time.Duration
+	// MaxFailures 连续失败多少次后移除, 默认3
+	MaxFailures int
+	Observer    event.Observer
+	Logger      logger.Logger
+}
+
+// NewMonitor 创建Monitor
+func NewMonitor(config *Config) *Monitor {
+	checker := config.Checker
+	if checker == nil {
+		checker = util.NewChecker(nil)
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	maxFailures := config.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	observer := config.Observer
+	if observer == nil {
+		observer = event.Nop()
+	}
+	log := config.Logger
+	if log == nil {
+		log = console.NewLogger()
+	}
+	return &Monitor{
+		checker:     checker,
+		interval:    interval,
+		jitter:      config.Jitter,
+		maxFailures: maxFailures,
+		observer:    observer,
+		logger:      log,
+		proxies:     map[string]*state{},
+	}
+}
+
+// Add 加入需要监控的代理
+func (m *Monitor) Add(proxies ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, proxy := range proxies {
+		if _, ok := m.proxies[proxy]; !ok {
+			m.proxies[proxy] = &state{}
+		}
+	}
+}
+
+// Remove 停止监控代理
+func (m *Monitor) Remove(proxy string) {
+	m.mu.Lock()
+	delete(m.proxies, proxy)
+	m.mu.Unlock()
+}
+
+// Proxies 当前仍在监控中的代理
+func (m *Monitor) Proxies() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	proxies := make([]string, 0, len(m.proxies))
+	for proxy := range m.proxies {
+		proxies = append(proxies, proxy)
+	}
+	return proxies
+}
+
+// Failures 代理当前连续失败次数, 代理不在监控中时ok为false
+func (m *Monitor) Failures(proxy string) (failures int, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.proxies[proxy]
+	if !ok {
+		return 0, false
+	}
+	return s.failures, true
+}
+
+// LastResult 代理最近一次检查结果, 尚未检查时为nil
+func (m *Monitor) LastResult(proxy string) *util.CheckResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.proxies[proxy]; ok {
+		return s.result
+	}
+	return nil
+}
+
+// CheckNow 立即检查一轮, 返回本轮被移除的代理
+func (m *Monitor) CheckNow(ctx context.Context) (evicted []string) {
+	proxies := m.Proxies()
+	if len(proxies) == 0 {
+		return nil
+	}
+	succ, fail := m.checker.CheckSync(ctx, proxies, m.observer)
+	if ctx != nil && ctx.Err() != nil {
+		// 检查被取消时结果不可信
+		return nil
+	}
+	m.mu.Lock()
+	for _, r := range succ {
+		if s, ok := m.proxies[r.Proxy]; ok {
+			s.failures = 0
+			s.result = r
+		}
+	}
+	for _, r := range fail {
+		s, ok := m.proxies[r.Proxy]
+		if !ok {
+			continue
+		}
+		s.failures++
+		s.result = r
+		if s.failures >= m.maxFailures {
+			delete(m.proxies, r.Proxy)
+			evicted = append(evicted, r.Proxy)
+		}
+	}
+	m.mu.Unlock()
+	for _, proxy := range evicted {
+		detail := fmt.Sprintf("连续%d次检查失败", m.maxFailures)
+		m.logger.Warn(fmt.Sprintf("[Monitor] 代理%s, 已移除. proxy=%s", detail, proxy))
+		m.observer.OnDiscarded(&event.DiscardedEvent{Proxy: proxy, Reason: event.DiscardCheckFailed, Detail: detail, Time: time.Now()})
+	}
+	return evicted
+}
+
+// Start 在后台定期检查, 直到ctx结束或调用Stop
+func (m *Monitor) Start(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
+}
+
+// Stop 停止后台检查, 并等待正在进行的检查结束
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (m *Monitor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		wait := m.interval
+		if m.jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(m.jitter)))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		m.CheckNow(ctx)
+	}
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/event"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitor_CheckNow(t *testing.T) {
+	convey.Convey("CheckNow", t, func() {
+		good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer good.Close()
+		dead := "http://127.0.0.1:1"
+		var discarded []string
+		m := NewMonitor(&Config{
+			MaxFailures: 2,
+			Observer: &event.Funcs{Discarded: func(e *event.DiscardedEvent) {
+				discarded = append(discarded, e.Proxy)
+			}},
+		})
+		m.Add(good.URL, dead)
+
+		convey.Convey("Proxy is evicted after K consecutive failures.", func() {
+			convey.So(m.CheckNow(context.TODO()), convey.ShouldBeEmpty)
+			failures, ok := m.Failures(dead)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(failures, convey.ShouldEqual, 1)
+
+			convey.So(m.CheckNow(context.TODO()), convey.ShouldResemble, []string{dead})
+			convey.So(m.Proxies(), convey.ShouldResemble, []string{good.URL})
+			convey.So(discarded, convey.ShouldResemble, []string{dead})
+			convey.So(m.LastResult(good.URL).Success, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Re-added proxy starts without failures.", func() {
+			m.CheckNow(context.TODO())
+			m.Remove(dead)
+			m.Add(dead)
+			failures, _ := m.Failures(dead)
+			convey.So(failures, convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestMonitor_Start(t *testing.T) {
+	convey.Convey("Start", t, func() {
+		var (
+			mu      sync.Mutex
+			checked int
+		)
+		m := NewMonitor(&Config{
+			Interval:    10 * time.Millisecond,
+			Jitter:      5 * time.Millisecond,
+			MaxFailures: 1,
+			Observer: &event.Funcs{Checked: func(e *event.CheckedEvent) {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			}},
+		})
+		m.Add("http://127.0.0.1:1")
+		m.Start(context.Background())
+		time.Sleep(200 * time.Millisecond)
+		m.Stop()
+		mu.Lock()
+		defer mu.Unlock()
+		convey.So(checked, convey.ShouldEqual, 1)
+		convey.So(m.Proxies(), convey.ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,42 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// Lease 代理租约
+type Lease struct {
+	// Proxy 代理连接串
+	Proxy string
+
+	pool     *Pool
+	entry    *entry
+	acquired time.Time
+	once     sync.Once
+}
+
+// Release 归还代理, 不报告结果
+//
+// Release, ReportSuccess, ReportFailure中只有第一次调用有效
+func (l *Lease) Release() {
+	l.finish(outcomeNone, "")
+}
+
+// ReportSuccess 报告请求成功并归还代理, 代理留在池中继续使用
+func (l *Lease) ReportSuccess() {
+	l.finish(outcomeSuccess, "")
+}
+
+// ReportFailure 报告请求失败并归还代理, 连续失败达到MaxFailures的代理被丢弃
+func (l *Lease) ReportFailure(reason string) {
+	l.finish(outcomeFailure, reason)
+}
+
+func (l *Lease) finish(result outcome, reason string) {
+	l.once.Do(func() {
+		l.pool.mu.Lock()
+		defer l.pool.mu.Unlock()
+		l.pool.release(l.entry, l.pool.now().Sub(l.acquired), result, reason)
+	})
+}
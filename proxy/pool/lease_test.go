@@ -0,0 +1,74 @@
+package pool
+
+import (
+	"context"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/proxy/selector"
+	"github.com/zx106kg/go-proxy/test"
+	"testing"
+)
+
+func TestLease(t *testing.T) {
+	convey.Convey("Lease", t, func() {
+		var discarded []*event.DiscardedEvent
+		observer := &event.Funcs{Discarded: func(e *event.DiscardedEvent) { discarded = append(discarded, e) }}
+
+		convey.Convey("Good proxies are reused without fetching new ones.", func() {
+			a := test.NewSequenceAdapter()
+			p := NewPool(&Config{Adapter: a, Size: 1})
+			for i := 0; i < 3; i++ {
+				l, err := p.Acquire(context.TODO())
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+				l.ReportSuccess()
+			}
+			convey.So(a.Served(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Failed proxies are dropped and replaced.", func() {
+			p := NewPool(&Config{Adapter: test.NewSequenceAdapter(), Size: 1, Observer: observer})
+			l, _ := p.Acquire(context.TODO())
+			l.ReportFailure("connection reset")
+			// 只有第一次报告有效
+			l.ReportSuccess()
+			convey.So(len(discarded), convey.ShouldEqual, 1)
+			convey.So(discarded[0].Reason, convey.ShouldEqual, event.DiscardBlacklisted)
+			convey.So(discarded[0].Detail, convey.ShouldEqual, "connection reset")
+
+			l, _ = p.Acquire(context.TODO())
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.2:8888")
+		})
+
+		convey.Convey("Success resets consecutive failures.", func() {
+			p := NewPool(&Config{Adapter: test.NewSequenceAdapter(), Size: 1, MaxFailures: 2, Observer: observer})
+			report := []func(l *Lease){
+				func(l *Lease) { l.ReportFailure("timeout") },
+				func(l *Lease) { l.ReportSuccess() },
+				func(l *Lease) { l.ReportFailure("timeout") },
+				func(l *Lease) { l.Release() },
+				func(l *Lease) { l.ReportFailure("timeout") },
+			}
+			for _, r := range report {
+				l, _ := p.Acquire(context.TODO())
+				convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+				r(l)
+			}
+			convey.So(len(discarded), convey.ShouldEqual, 1)
+			convey.So(p.Len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Leases are reported to the selector.", func() {
+			s := selector.NewLeastInFlight()
+			p := NewPool(&Config{Adapter: test.NewSequenceAdapter(), Size: 2, Selector: s})
+			l1, _ := p.Acquire(context.TODO())
+			l2, _ := p.Acquire(context.TODO())
+			convey.So(l1.Proxy, convey.ShouldNotEqual, l2.Proxy)
+			convey.So(s.InFlight(l1.Proxy), convey.ShouldEqual, 1)
+			l1.ReportSuccess()
+			convey.So(s.InFlight(l1.Proxy), convey.ShouldEqual, 0)
+			l3, _ := p.Acquire(context.TODO())
+			convey.So(l3.Proxy, convey.ShouldEqual, l1.Proxy)
+		})
+	})
+}
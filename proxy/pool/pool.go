@@ -0,0 +1,375 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/logger"
+	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/proxy/adapter"
+	"github.com/zx106kg/go-proxy/proxy/selector"
+	"sync"
+	"time"
+)
+
+// Pool 代理池, 以租约形式分配代理并统计使用情况
+//
+// 代理服务满MaxRequests次请求或首次使用后超过MaxLifetime即退役, 无论是否健康.
+// 可用代理少于LowWater时从adapter补充到Size, 同一时间只有一个补充请求, 不持有锁等待供应商.
+// 仍有可用代理时在后台补充, 只有池为空时Acquire才等待补充完成.
+// 通过租约报告失败的代理连续失败MaxFailures次后被丢弃, 成功的代理留在池中继续使用.
+// 退役或丢弃的代理在RetiredTtl内即使被供应商再次返回也不会重新加入, adapter实现adapter.FixedEndpoint时除外.
+// 外部检查发现不可用的代理可通过Remove移除.
+type Pool struct {
+	adapter     adapter.ProxyVendorAdapter
+	checked     bool
+	fixed       bool
+	size        int
+	lowWater    int
+	maxRequests int
+	maxLifetime time.Duration
+	maxFailures int
+	retiredTtl  time.Duration
+	selector    selector.Selector
+	observer    event.Observer
+	logger      logger.Logger
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	order   []string
+	// retired 退役或丢弃的代理及其时间
+	retired map[string]time.Time
+	// refilling 正在补充时不为空, 补充结束后关闭
+	refilling chan struct{}
+}
+
+// entry 代理的使用统计
+type entry struct {
+	proxy     string
+	requests  int
+	firstUsed time.Time
+	inUse     int
+	failures  int
+}
+
+type Config struct {
+	Adapter adapter.ProxyVendorAdapter
+	// Checked 是否只使用已检查连通性的代理
+	Checked bool
+	// Size 池中保持的可用代理数量, 默认10
+	Size int
+	// LowWater 可用代理少于此数量时补充到Size, 默认Size的一半, 至少为1
+	LowWater int
+	// MaxRequests 每个代理最多服务的请求数, 0为不限制
+	MaxRequests int
+	// MaxLifetime 代理首次使用后最长使用时间, 0为不限制
+	MaxLifetime time.Duration
+	// MaxFailures 连续失败多少次后丢弃代理, 默认1
+	MaxFailures int
+	// RetiredTtl 退役或丢弃的代理多久内不再加入, 默认30分钟, 不小于MaxLifetime.
+	// Adapter实现adapter.FixedEndpoint且返回true时, 每次返回的都是同一地址, 退役后允许立即重新加入
+	RetiredTtl time.Duration
+	// Selector 代理选择策略, 默认轮流选择
+	Selector selector.Selector
+	Observer event.Observer
+	Logger   logger.Logger
+}
+
+// NewPool 创建Pool
+func NewPool(config *Config) *Pool {
+	size := config.Size
+	if size <= 0 {
+		size = 10
+	}
+	lowWater := config.LowWater
+	if lowWater <= 0 {
+		lowWater = size / 2
+	}
+	if lowWater < 1 {
+		lowWater = 1
+	} else if lowWater > size {
+		lowWater = size
+	}
+	maxFailures := config.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 1
+	}
+	retiredTtl := config.RetiredTtl
+	if retiredTtl <= 0 {
+		retiredTtl = 30 * time.Minute
+	}
+	if retiredTtl < config.MaxLifetime {
+		retiredTtl = config.MaxLifetime
+	}
+	sel := config.Selector
+	if sel == nil {
+		sel = selector.NewRoundRobin()
+	}
+	observer := config.Observer
+	if observer == nil {
+		observer = event.Nop()
+	}
+	log := config.Logger
+	if log == nil {
+		log = console.NewLogger()
+	}
+	fixed := false
+	if a, ok := config.Adapter.(adapter.FixedEndpoint); ok {
+		fixed = a.FixedEndpoint()
+	}
+	return &Pool{
+		adapter:     config.Adapter,
+		checked:     config.Checked,
+		fixed:       fixed,
+		size:        size,
+		lowWater:    lowWater,
+		maxRequests: config.MaxRequests,
+		maxLifetime: config.MaxLifetime,
+		maxFailures: maxFailures,
+		retiredTtl:  retiredTtl,
+		selector:    sel,
+		observer:    observer,
+		logger:      log,
+		now:         time.Now,
+		entries:     map[string]*entry{},
+		retired:     map[string]time.Time{},
+	}
+}
+
+// Acquire 获取一个代理的租约, 每个租约计为代理服务的一次请求
+//
+// 使用结束后必须调用Lease.Release, Lease.ReportSuccess或Lease.ReportFailure之一
+func (p *Pool) Acquire(ctx context.Context) (*Lease, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		p.retireExpired()
+		if len(p.order) >= p.lowWater {
+			break
+		}
+		if p.refilling == nil {
+			p.refilling = make(chan struct{})
+			if len(p.order) > 0 {
+				// 仍有可用代理, 在后台补充, 不阻塞本次获取
+				go p.replenishBackground()
+				break
+			}
+			if err := p.replenish(ctx); err != nil && len(p.order) == 0 {
+				return nil, err
+			}
+			break
+		}
+		if len(p.order) > 0 {
+			// 其他调用正在补充, 先使用现有代理
+			break
+		}
+		// 等待其他调用补充完成
+		refilling := p.refilling
+		p.mu.Unlock()
+		select {
+		case <-refilling:
+		case <-ctx.Done():
+			p.mu.Lock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+	proxy, err := p.selector.Select(p.order)
+	if err != nil {
+		return nil, err
+	}
+	e := p.entries[proxy]
+	now := p.now()
+	if e.requests == 0 {
+		e.firstUsed = now
+	}
+	e.requests++
+	e.inUse++
+	if p.maxRequests > 0 && e.requests >= p.maxRequests {
+		p.retire(e, fmt.Sprintf("已服务%d次请求", e.requests))
+	}
+	return &Lease{Proxy: proxy, pool: p, entry: e, acquired: now}, nil
+}
+
+// Len 池中可用代理数量
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.order)
+}
+
+// Proxies 池中可用的代理, 可用于交给health.Monitor等外部检查
+func (p *Pool) Proxies() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.order...)
+}
+
+// Remove 从池中移除代理, 用于外部检查发现代理不可用时, 如health.Monitor的DiscardCheckFailed事件.
+// 进行中的租约不受影响, 移除的代理在RetiredTtl内不会重新加入. 代理不在池中时返回false.
+// 不触发DiscardedEvent, 由发现问题的一方报告
+func (p *Pool) Remove(proxy string, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.contains(proxy) {
+		return false
+	}
+	p.remove(proxy)
+	p.logger.Warn(fmt.Sprintf("[Pool] 代理已移除. proxy=%s, %s", proxy, reason))
+	return true
+}
+
+// Usage 返回代理已服务的请求数, 代理不在池中时返回false
+func (p *Pool) Usage(proxy string) (requests int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[proxy]
+	if !ok {
+		return 0, false
+	}
+	return e.requests, true
+}
+
+// replenishBackground 在后台补充, 调用前需已设置refilling
+func (p *Pool) replenishBackground() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_ = p.replenish(context.Background())
+}
+
+// replenish 从adapter补充代理到Size. 需持有mu并已设置refilling, 请求供应商期间释放mu
+func (p *Pool) replenish(ctx context.Context) error {
+	refilling := p.refilling
+	count := p.size - len(p.order)
+	p.mu.Unlock()
+	var (
+		proxies []string
+		err     error
+	)
+	if p.checked {
+		proxies, err = p.adapter.GetCheckedProxiesSync(ctx, count, true)
+	} else {
+		proxies, err = p.adapter.GetProxiesSync(ctx, count, true)
+	}
+	p.mu.Lock()
+	p.refilling = nil
+	close(refilling)
+	if err != nil {
+		p.logger.Warn(fmt.Sprintf("[Pool] 补充代理失败. %v", err))
+		// 出错时返回的代理照常加入, 见adapter.ProxyVendorAdapter
+		if len(proxies) == 0 {
+			return err
+		}
+	}
+	p.pruneRetired()
+	for _, proxy := range proxies {
+		if _, ok := p.entries[proxy]; ok {
+			continue
+		}
+		if _, ok := p.retired[proxy]; ok && !p.fixed {
+			p.logger.Info(fmt.Sprintf("[Pool] 忽略已退役的代理. proxy=%s", proxy))
+			continue
+		}
+		p.entries[proxy] = &entry{proxy: proxy}
+		p.order = append(p.order, proxy)
+	}
+	if len(p.order) == 0 {
+		if err != nil {
+			return err
+		}
+		return selector.ErrNoProxy
+	}
+	return err
+}
+
+// retireExpired 退役超过MaxLifetime的代理. 需持有mu
+func (p *Pool) retireExpired() {
+	if p.maxLifetime <= 0 {
+		return
+	}
+	now := p.now()
+	for _, proxy := range append([]string(nil), p.order...) {
+		e := p.entries[proxy]
+		if e.requests > 0 && now.Sub(e.firstUsed) >= p.maxLifetime {
+			p.retire(e, "超过最长使用时间")
+		}
+	}
+}
+
+// retire 代理不再分配, 进行中的租约不受影响. 需持有mu
+func (p *Pool) retire(e *entry, detail string) {
+	p.remove(e.proxy)
+	p.logger.Info(fmt.Sprintf("[Pool] 代理已退役. proxy=%s, %s", e.proxy, detail))
+	p.observer.OnDiscarded(&event.DiscardedEvent{Proxy: e.proxy, Reason: event.DiscardExpired, Detail: detail, Time: p.now()})
+}
+
+// pruneRetired 清除超过RetiredTtl的退役记录. 需持有mu
+func (p *Pool) pruneRetired() {
+	now := p.now()
+	for proxy, at := range p.retired {
+		if now.Sub(at) >= p.retiredTtl {
+			delete(p.retired, proxy)
+		}
+	}
+}
+
+// remove 从可用代理中移除, 并记录为已退役. 需持有mu
+func (p *Pool) remove(proxy string) {
+	p.retired[proxy] = p.now()
+	for i, v := range p.order {
+		if v == proxy {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+	if e, ok := p.entries[proxy]; ok && e.inUse == 0 {
+		delete(p.entries, proxy)
+		p.selector.Forget(proxy)
+	}
+}
+
+// outcome 租约报告的结果
+type outcome int
+
+const (
+	outcomeNone outcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// release 租约结束, 按结果更新连续失败次数. 需持有mu
+func (p *Pool) release(e *entry, latency time.Duration, result outcome, reason string) {
+	e.inUse--
+	var err error
+	switch result {
+	case outcomeSuccess:
+		e.failures = 0
+	case outcomeFailure:
+		err = errors.New(reason)
+		e.failures++
+		if e.failures >= p.maxFailures && p.contains(e.proxy) {
+			p.remove(e.proxy)
+			p.logger.Warn(fmt.Sprintf("[Pool] 代理连续失败%d次, 已丢弃. proxy=%s, %s", e.failures, e.proxy, reason))
+			p.observer.OnDiscarded(&event.DiscardedEvent{Proxy: e.proxy, Reason: event.DiscardBlacklisted, Detail: reason, Time: p.now()})
+		}
+	}
+	p.selector.Done(e.proxy, latency, err)
+	if e.inUse == 0 && !p.contains(e.proxy) {
+		delete(p.entries, e.proxy)
+		p.selector.Forget(e.proxy)
+	}
+}
+
+func (p *Pool) contains(proxy string) bool {
+	for _, v := range p.order {
+		if v == proxy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/proxy/budget"
+	"github.com/zx106kg/go-proxy/proxy/health"
+	"github.com/zx106kg/go-proxy/test"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixedEndpoint 每次返回同一地址的adapter
+type fixedEndpoint struct {
+	*test.Adapter
+}
+
+func (a *fixedEndpoint) FixedEndpoint() bool {
+	return true
+}
+
+// waitRefill 等待进行中的补充完成
+func waitRefill(p *Pool) {
+	p.mu.Lock()
+	refilling := p.refilling
+	p.mu.Unlock()
+	if refilling != nil {
+		<-refilling
+	}
+}
+
+func TestPool_Acquire(t *testing.T) {
+	convey.Convey("Acquire", t, func() {
+		var discarded []*event.DiscardedEvent
+		observer := &event.Funcs{Discarded: func(e *event.DiscardedEvent) { discarded = append(discarded, e) }}
+
+		convey.Convey("Proxies are retired after MaxRequests and replenished.", func() {
+			a := test.NewSequenceAdapter()
+			p := NewPool(&Config{Adapter: a, Size: 2, MaxRequests: 2, Observer: observer})
+			var proxies []string
+			for i := 0; i < 4; i++ {
+				l, err := p.Acquire(context.TODO())
+				convey.So(err, convey.ShouldBeNil)
+				proxies = append(proxies, l.Proxy)
+				l.Release()
+			}
+			convey.So(proxies, convey.ShouldResemble, []string{
+				"http://192.168.0.1:8888", "http://192.168.0.2:8888", "http://192.168.0.1:8888", "http://192.168.0.2:8888",
+			})
+			convey.So(len(discarded), convey.ShouldEqual, 2)
+			convey.So(discarded[0].Reason, convey.ShouldEqual, event.DiscardExpired)
+			_, ok := p.Usage("http://192.168.0.1:8888")
+			convey.So(ok, convey.ShouldBeFalse)
+
+			l, _ := p.Acquire(context.TODO())
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.3:8888")
+			convey.So(p.Len(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Proxies are retired after MaxLifetime.", func() {
+			p := NewPool(&Config{Adapter: test.NewSequenceAdapter(), Size: 1, MaxLifetime: time.Minute, Observer: observer})
+			now := time.Now()
+			p.now = func() time.Time { return now }
+
+			l, _ := p.Acquire(context.TODO())
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+			now = now.Add(30 * time.Second)
+			l2, _ := p.Acquire(context.TODO())
+			convey.So(l2.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+			requests, _ := p.Usage(l.Proxy)
+			convey.So(requests, convey.ShouldEqual, 2)
+
+			now = now.Add(30 * time.Second)
+			l3, _ := p.Acquire(context.TODO())
+			convey.So(l3.Proxy, convey.ShouldEqual, "http://192.168.0.2:8888")
+			convey.So(len(discarded), convey.ShouldEqual, 1)
+
+			// 退役的代理在租约归还前仍保留统计
+			requests, ok := p.Usage(l.Proxy)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(requests, convey.ShouldEqual, 2)
+			l.Release()
+			l.Release()
+			l2.Release()
+			_, ok = p.Usage(l.Proxy)
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Retired proxies are not readmitted within RetiredTtl.", func() {
+			a := test.NewFixedAdapter("http://192.168.0.1:8888")
+			p := NewPool(&Config{Adapter: a, Size: 1, MaxRequests: 1, RetiredTtl: time.Minute, Observer: observer})
+			now := time.Now()
+			p.now = func() time.Time { return now }
+
+			l, err := p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeNil)
+			l.Release()
+			_, err = p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeError)
+
+			now = now.Add(time.Minute)
+			l, err = p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+		})
+
+		convey.Convey("Failed proxies are not readmitted.", func() {
+			a := test.NewFixedAdapter("http://192.168.0.1:8888", "http://192.168.0.2:8888")
+			p := NewPool(&Config{Adapter: a, Size: 2, LowWater: 2})
+			l, _ := p.Acquire(context.TODO())
+			l.ReportFailure("connection reset")
+			for i := 0; i < 3; i++ {
+				l, err := p.Acquire(context.TODO())
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.2:8888")
+				l.ReportSuccess()
+			}
+		})
+
+		convey.Convey("Replenish below LowWater only.", func() {
+			a := test.NewSequenceAdapter()
+			p := NewPool(&Config{Adapter: a, Size: 4, LowWater: 2, MaxRequests: 1})
+			for i := 0; i < 3; i++ {
+				l, _ := p.Acquire(context.TODO())
+				l.Release()
+			}
+			convey.So(a.Served(), convey.ShouldEqual, 4)
+			convey.So(p.Len(), convey.ShouldEqual, 1)
+			l, _ := p.Acquire(context.TODO())
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.4:8888")
+			// 后台补充在本次获取之后计算数量, 补充到Size
+			waitRefill(p)
+			convey.So(a.Served(), convey.ShouldEqual, 8)
+			convey.So(p.Len(), convey.ShouldEqual, 4)
+		})
+
+		convey.Convey("Refill in the background while proxies remain.", func() {
+			a := test.NewSequenceAdapter()
+			p := NewPool(&Config{Adapter: a, Size: 2, LowWater: 2, MaxRequests: 1})
+			l, _ := p.Acquire(context.TODO())
+			l.Release()
+			a.Wait = make(chan struct{})
+			l, err := p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.2:8888")
+			convey.So(p.Len(), convey.ShouldEqual, 0)
+			close(a.Wait)
+			waitRefill(p)
+			convey.So(p.Len(), convey.ShouldEqual, 2)
+			convey.So(a.Calls(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Fixed endpoints are readmitted after retirement.", func() {
+			a := &fixedEndpoint{Adapter: test.NewFixedAdapter("http://gw.vendor.com:8888")}
+			p := NewPool(&Config{Adapter: a, Size: 1, MaxRequests: 1})
+			for i := 0; i < 3; i++ {
+				l, err := p.Acquire(context.TODO())
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(l.Proxy, convey.ShouldEqual, "http://gw.vendor.com:8888")
+				l.Release()
+			}
+		})
+
+		convey.Convey("Concurrent acquires share one refill.", func() {
+			a := test.NewFixedAdapter("http://192.168.0.1:8888")
+			a.Wait = make(chan struct{})
+			p := NewPool(&Config{Adapter: a, Size: 1})
+			var wg sync.WaitGroup
+			errs := make([]error, 3)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					l, err := p.Acquire(context.TODO())
+					errs[i] = err
+					if err == nil {
+						l.Release()
+					}
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			// 等待供应商期间不持有锁
+			convey.So(p.Len(), convey.ShouldEqual, 0)
+			close(a.Wait)
+			wg.Wait()
+			convey.So(errs, convey.ShouldResemble, []error{nil, nil, nil})
+			convey.So(a.Calls(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Waiting for a refill stops when ctx is done.", func() {
+			a := test.NewFixedAdapter("http://192.168.0.1:8888")
+			a.Wait = make(chan struct{})
+			defer close(a.Wait)
+			p := NewPool(&Config{Adapter: a, Size: 1})
+			go func() {
+				_, _ = p.Acquire(context.TODO())
+			}()
+			time.Sleep(20 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := p.Acquire(ctx)
+			convey.So(err, convey.ShouldEqual, context.DeadlineExceeded)
+		})
+
+		convey.Convey("Proxies returned with a budget error are kept.", func() {
+			a := test.NewSequenceAdapter()
+			a.Err = &budget.ExceededError{Window: "hourly", Limit: 1, Used: 1}
+			a.Limit = 1
+			p := NewPool(&Config{Adapter: a, Size: 2})
+			l, err := p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(l.Proxy, convey.ShouldEqual, "http://192.168.0.1:8888")
+			convey.So(p.Len(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Return the adapter error when the pool is empty.", func() {
+			a := test.NewQueueAdapter()
+			a.Err = errors.New("vendor failed")
+			p := NewPool(&Config{Adapter: a})
+			_, err := p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeError)
+		})
+	})
+}
+
+func TestPool_Remove(t *testing.T) {
+	convey.Convey("Remove", t, func() {
+		good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer good.Close()
+		dead := "http://127.0.0.1:1"
+		a := test.NewFixedAdapter(good.URL, dead)
+		p := NewPool(&Config{Adapter: a, Size: 2, LowWater: 1})
+
+		convey.Convey("Proxies evicted by a health.Monitor are removed from the pool.", func() {
+			m := health.NewMonitor(&health.Config{
+				MaxFailures: 1,
+				Observer: &event.Funcs{Discarded: func(e *event.DiscardedEvent) {
+					p.Remove(e.Proxy, e.Detail)
+				}},
+			})
+			l, err := p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeNil)
+			l.Release()
+			m.Add(p.Proxies()...)
+			convey.So(m.CheckNow(context.TODO()), convey.ShouldResemble, []string{dead})
+			convey.So(p.Proxies(), convey.ShouldResemble, []string{good.URL})
+
+			// 移除的代理不会被再次加入
+			p.Remove(good.URL, "test")
+			_, err = p.Acquire(context.TODO())
+			convey.So(err, convey.ShouldBeError)
+			convey.So(a.Calls(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Removing a proxy not in the pool.", func() {
+			convey.So(p.Remove(dead, "test"), convey.ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package selector
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LatencyWeighted 按延迟加权随机选择, 权重与平均延迟成反比
+//
+// 平均延迟为指数加权移动平均, 没有延迟数据的代理使用已知代理的平均值.
+type LatencyWeighted struct {
+	alpha          float64
+	failurePenalty time.Duration
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	latency map[string]float64
+}
+
+type LatencyWeightedConfig struct {
+	// Alpha 移动平均中新样本的权重, 取值(0, 1], 默认0.3
+	Alpha float64
+	// FailurePenalty 请求失败时计入的延迟, 默认5秒
+	FailurePenalty time.Duration
+}
+
+// NewLatencyWeighted 创建LatencyWeighted, config可以为空
+func NewLatencyWeighted(config *LatencyWeightedConfig) *LatencyWeighted {
+	if config == nil {
+		config = &LatencyWeightedConfig{}
+	}
+	alpha := config.Alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	failurePenalty := config.FailurePenalty
+	if failurePenalty <= 0 {
+		failurePenalty = 5 * time.Second
+	}
+	return &LatencyWeighted{
+		alpha:          alpha,
+		failurePenalty: failurePenalty,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		latency:        map[string]float64{},
+	}
+}
+
+func (s *LatencyWeighted) Select(proxies []string) (string, error) {
+	if len(proxies) == 0 {
+		return "", ErrNoProxy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sum float64
+	var known int
+	for _, p := range proxies {
+		if l, ok := s.latency[p]; ok {
+			sum += l
+			known++
+		}
+	}
+	// 没有延迟数据时按1处理, 即等权随机
+	fallback := 1.0
+	if known > 0 {
+		fallback = sum / float64(known)
+	}
+	weights := make([]float64, len(proxies))
+	var total float64
+	for i, p := range proxies {
+		l, ok := s.latency[p]
+		if !ok {
+			l = fallback
+		}
+		if l < 1 {
+			l = 1
+		}
+		weights[i] = 1 / l
+		total += weights[i]
+	}
+	r := s.rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return proxies[i], nil
+		}
+		r -= w
+	}
+	return proxies[len(proxies)-1], nil
+}
+
+// Done 记录延迟, 失败时按FailurePenalty与实际耗时中的较大值计入
+func (s *LatencyWeighted) Done(proxy string, latency time.Duration, err error) {
+	if err != nil && latency < s.failurePenalty {
+		latency = s.failurePenalty
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.latency[proxy]; ok {
+		s.latency[proxy] = s.alpha*float64(latency) + (1-s.alpha)*l
+	} else {
+		s.latency[proxy] = float64(latency)
+	}
+}
+
+func (s *LatencyWeighted) Forget(proxy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.latency, proxy)
+}
+
+// Latency 返回proxy的平均延迟, 没有数据时返回false
+func (s *LatencyWeighted) Latency(proxy string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.latency[proxy]
+	return time.Duration(l), ok
+}
@@ -0,0 +1,174 @@
+package selector
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrNoProxy 没有可选择的代理
+var ErrNoProxy = errors.New("没有可用的代理")
+
+// Selector 从一组健康代理中选择一个
+//
+// 实现需要并发安全. 代理池, RoundTripper等使用方在请求结束后调用Done,
+// 代理被移除后调用Forget释放统计数据.
+type Selector interface {
+	// Select 选择一个代理, proxies为空时返回ErrNoProxy
+	Select(proxies []string) (proxy string, err error)
+	// Done 通过proxy的请求结束, latency为请求耗时, err为请求结果
+	Done(proxy string, latency time.Duration, err error)
+	// Forget 清除proxy的统计数据
+	Forget(proxy string)
+}
+
+// RoundRobin 轮流选择
+type RoundRobin struct {
+	mu    sync.Mutex
+	last  string
+	index int
+}
+
+// NewRoundRobin 创建RoundRobin
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{}
+}
+
+// Select 选择上次选中代理的下一个. 上次选中的代理已被移除时, 选择顶替其位置的代理
+func (s *RoundRobin) Select(proxies []string) (string, error) {
+	if len(proxies) == 0 {
+		return "", ErrNoProxy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.index
+	for i, p := range proxies {
+		if p == s.last {
+			next = i + 1
+			break
+		}
+	}
+	if next >= len(proxies) {
+		next = 0
+	}
+	s.last, s.index = proxies[next], next
+	return s.last, nil
+}
+
+func (s *RoundRobin) Done(string, time.Duration, error) {}
+
+func (s *RoundRobin) Forget(string) {}
+
+// Random 随机选择
+type Random struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewRandom 创建Random
+func NewRandom() *Random {
+	return &Random{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *Random) Select(proxies []string) (string, error) {
+	if len(proxies) == 0 {
+		return "", ErrNoProxy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return proxies[s.rand.Intn(len(proxies))], nil
+}
+
+func (s *Random) Done(string, time.Duration, error) {}
+
+func (s *Random) Forget(string) {}
+
+// LeastRecentlyUsed 选择最久没有被选中的代理, 从未选中的代理优先
+type LeastRecentlyUsed struct {
+	mu   sync.Mutex
+	seq  uint64
+	used map[string]uint64
+}
+
+// NewLeastRecentlyUsed 创建LeastRecentlyUsed
+func NewLeastRecentlyUsed() *LeastRecentlyUsed {
+	return &LeastRecentlyUsed{used: map[string]uint64{}}
+}
+
+func (s *LeastRecentlyUsed) Select(proxies []string) (string, error) {
+	if len(proxies) == 0 {
+		return "", ErrNoProxy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proxy := proxies[0]
+	for _, p := range proxies[1:] {
+		if s.used[p] < s.used[proxy] {
+			proxy = p
+		}
+	}
+	s.seq++
+	s.used[proxy] = s.seq
+	return proxy, nil
+}
+
+func (s *LeastRecentlyUsed) Done(string, time.Duration, error) {}
+
+func (s *LeastRecentlyUsed) Forget(proxy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.used, proxy)
+}
+
+// LeastInFlight 选择进行中请求最少的代理
+//
+// Select计入一个进行中的请求, Done时减少
+type LeastInFlight struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// NewLeastInFlight 创建LeastInFlight
+func NewLeastInFlight() *LeastInFlight {
+	return &LeastInFlight{inFlight: map[string]int{}}
+}
+
+func (s *LeastInFlight) Select(proxies []string) (string, error) {
+	if len(proxies) == 0 {
+		return "", ErrNoProxy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proxy := proxies[0]
+	for _, p := range proxies[1:] {
+		if s.inFlight[p] < s.inFlight[proxy] {
+			proxy = p
+		}
+	}
+	s.inFlight[proxy]++
+	return proxy, nil
+}
+
+func (s *LeastInFlight) Done(proxy string, _ time.Duration, _ error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[proxy] > 1 {
+		s.inFlight[proxy]--
+	} else {
+		delete(s.inFlight, proxy)
+	}
+}
+
+func (s *LeastInFlight) Forget(proxy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, proxy)
+}
+
+// InFlight 返回proxy进行中的请求数
+func (s *LeastInFlight) InFlight(proxy string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight[proxy]
+}
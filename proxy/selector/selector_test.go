@@ -0,0 +1,128 @@
+package selector
+
+import (
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestSelectors_Empty(t *testing.T) {
+	convey.Convey("Empty proxies", t, func() {
+		for _, s := range []Selector{NewRoundRobin(), NewRandom(), NewLeastRecentlyUsed(), NewLeastInFlight(), NewLatencyWeighted(nil)} {
+			_, err := s.Select(nil)
+			convey.So(err, convey.ShouldEqual, ErrNoProxy)
+		}
+	})
+}
+
+func TestRoundRobin(t *testing.T) {
+	convey.Convey("RoundRobin", t, func() {
+		s := NewRoundRobin()
+		proxies := []string{"a", "b", "c"}
+
+		convey.Convey("Select in turn", func() {
+			var picked []string
+			for i := 0; i < 4; i++ {
+				p, _ := s.Select(proxies)
+				picked = append(picked, p)
+			}
+			convey.So(picked, convey.ShouldResemble, []string{"a", "b", "c", "a"})
+		})
+
+		convey.Convey("Continue with the successor when the last one is removed", func() {
+			p, _ := s.Select(proxies)
+			convey.So(p, convey.ShouldEqual, "a")
+			p, _ = s.Select([]string{"b", "c"})
+			convey.So(p, convey.ShouldEqual, "b")
+			p, _ = s.Select([]string{"a", "b", "c", "d"})
+			convey.So(p, convey.ShouldEqual, "c")
+		})
+	})
+}
+
+func TestRandom(t *testing.T) {
+	convey.Convey("Random", t, func() {
+		s := NewRandom()
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			p, _ := s.Select([]string{"a", "b"})
+			seen[p] = true
+		}
+		convey.So(len(seen), convey.ShouldEqual, 2)
+	})
+}
+
+func TestLeastRecentlyUsed(t *testing.T) {
+	convey.Convey("LeastRecentlyUsed", t, func() {
+		s := NewLeastRecentlyUsed()
+		p, _ := s.Select([]string{"a", "b"})
+		convey.So(p, convey.ShouldEqual, "a")
+		// 从未使用的c优先, 其次是最久未使用的b
+		p, _ = s.Select([]string{"a", "b", "c"})
+		convey.So(p, convey.ShouldEqual, "b")
+		p, _ = s.Select([]string{"a", "b", "c"})
+		convey.So(p, convey.ShouldEqual, "c")
+		p, _ = s.Select([]string{"a", "b", "c"})
+		convey.So(p, convey.ShouldEqual, "a")
+
+		s.Forget("c")
+		p, _ = s.Select([]string{"a", "b", "c"})
+		convey.So(p, convey.ShouldEqual, "c")
+	})
+}
+
+func TestLeastInFlight(t *testing.T) {
+	convey.Convey("LeastInFlight", t, func() {
+		s := NewLeastInFlight()
+		proxies := []string{"a", "b"}
+		p1, _ := s.Select(proxies)
+		p2, _ := s.Select(proxies)
+		convey.So([]string{p1, p2}, convey.ShouldResemble, []string{"a", "b"})
+		convey.So(s.InFlight("a"), convey.ShouldEqual, 1)
+
+		s.Done("b", time.Millisecond, nil)
+		p, _ := s.Select(proxies)
+		convey.So(p, convey.ShouldEqual, "b")
+		p, _ = s.Select(proxies)
+		convey.So(p, convey.ShouldEqual, "a")
+		convey.So(s.InFlight("a"), convey.ShouldEqual, 2)
+
+		s.Forget("a")
+		convey.So(s.InFlight("a"), convey.ShouldEqual, 0)
+	})
+}
+
+func TestLatencyWeighted(t *testing.T) {
+	convey.Convey("LatencyWeighted", t, func() {
+		s := NewLatencyWeighted(&LatencyWeightedConfig{Alpha: 0.5, FailurePenalty: time.Second})
+
+		convey.Convey("Moving average and failure penalty", func() {
+			s.Done("a", 100*time.Millisecond, nil)
+			s.Done("a", 300*time.Millisecond, nil)
+			l, ok := s.Latency("a")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(l, convey.ShouldEqual, 200*time.Millisecond)
+
+			s.Done("b", 10*time.Millisecond, errors.New("timeout"))
+			l, _ = s.Latency("b")
+			convey.So(l, convey.ShouldEqual, time.Second)
+
+			s.Forget("b")
+			_, ok = s.Latency("b")
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Faster proxies are selected more often", func() {
+			s.Done("fast", 10*time.Millisecond, nil)
+			s.Done("slow", time.Second, nil)
+			counts := map[string]int{}
+			for i := 0; i < 1000; i++ {
+				p, _ := s.Select([]string{"fast", "slow"})
+				counts[p]++
+			}
+			convey.So(counts["fast"], convey.ShouldBeGreaterThan, 900)
+			convey.So(counts["slow"], convey.ShouldBeGreaterThan, 0)
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/logger"
+	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/proxy/adapter"
+	"sync"
+	"time"
+)
+
+// Manager 按会话ID固定代理
+//
+// 同一会话在有效期内始终返回同一个代理, 过期或报告失败后重新分配.
+// 同一会话同一时间只有一个获取请求, 过期会话在访问时按MaxLifetime间隔清理.
+type Manager struct {
+	adapter     adapter.ProxyVendorAdapter
+	checked     bool
+	maxLifetime time.Duration
+	observer    event.Observer
+	logger      logger.Logger
+	now         func() time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	lastPrune time.Time
+}
+
+type session struct {
+	proxy    string
+	assigned time.Time
+	// fetching 正在获取代理时不为空, 获取结束后关闭
+	fetching chan struct{}
+}
+
+type Config struct {
+	Adapter adapter.ProxyVendorAdapter
+	// Checked 是否只分配已检查连通性的代理
+	Checked bool
+	// MaxLifetime 会话最长使用同一代理的时间, 默认10分钟
+	MaxLifetime time.Duration
+	Observer    event.Observer
+	Logger      logger.Logger
+}
+
+// NewManager 创建Manager
+func NewManager(config *Config) *Manager {
+	maxLifetime := config.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = 10 * time.Minute
+	}
+	observer := config.Observer
+	if observer == nil {
+		observer = event.Nop()
+	}
+	log := config.Logger
+	if log == nil {
+		log = console.NewLogger()
+	}
+	return &Manager{
+		adapter:     config.Adapter,
+		checked:     config.Checked,
+		maxLifetime: maxLifetime,
+		observer:    observer,
+		logger:      log,
+		now:         time.Now,
+		sessions:    map[string]*session{},
+	}
+}
+
+// GetProxyForSession 获取会话绑定的代理
+//
+// 会话不存在或已过期时, 从adapter获取新代理并绑定. 同一会话正在获取时等待其完成
+func (m *Manager) GetProxyForSession(ctx context.Context, sessionID string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	m.mu.Lock()
+	now := m.now()
+	var expired []string
+	if now.Sub(m.lastPrune) >= m.maxLifetime {
+		expired = m.pruneLocked(now)
+	}
+	for {
+		s, ok := m.sessions[sessionID]
+		if !ok {
+			break
+		}
+		if s.fetching != nil {
+			fetching := s.fetching
+			m.mu.Unlock()
+			select {
+			case <-fetching:
+			case <-ctx.Done():
+				m.notifyExpired(expired, now)
+				return "", ctx.Err()
+			}
+			m.mu.Lock()
+			continue
+		}
+		if m.now().Sub(s.assigned) < m.maxLifetime {
+			m.mu.Unlock()
+			m.notifyExpired(expired, now)
+			return s.proxy, nil
+		}
+		delete(m.sessions, sessionID)
+		expired = append(expired, s.proxy)
+		break
+	}
+	pending := &session{fetching: make(chan struct{})}
+	m.sessions[sessionID] = pending
+	m.mu.Unlock()
+	m.notifyExpired(expired, now)
+
+	proxy, err := m.fetch(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	close(pending.fetching)
+	pending.fetching = nil
+	if m.sessions[sessionID] != pending {
+		// 获取期间会话已结束
+		if err != nil {
+			return "", err
+		}
+		return proxy, nil
+	}
+	if err != nil {
+		delete(m.sessions, sessionID)
+		return "", err
+	}
+	pending.proxy = proxy
+	pending.assigned = m.now()
+	return proxy, nil
+}
+
+// ReportFailure 报告会话代理不可用, 下次获取时重新分配
+func (m *Manager) ReportFailure(sessionID string, reason string) {
+	m.mu.Lock()
+	s, ok := m.sessions[sessionID]
+	// 正在获取的会话尚未绑定代理
+	ok = ok && s.fetching == nil
+	if ok {
+		delete(m.sessions, sessionID)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	m.logger.Warn(fmt.Sprintf("[Session] 会话代理不可用, 已解除绑定. session=%s, proxy=%s, %s", sessionID, s.proxy, reason))
+	m.observer.OnDiscarded(&event.DiscardedEvent{Proxy: s.proxy, Reason: event.DiscardBlacklisted, Detail: reason, Time: m.now()})
+}
+
+// Release 结束会话
+func (m *Manager) Release(sessionID string) {
+	m.mu.Lock()
+	delete(m.sessions, sessionID)
+	m.mu.Unlock()
+}
+
+// Len 当前会话数量, 包含尚未清理的过期会话和正在获取代理的会话
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// Prune 清理过期会话
+func (m *Manager) Prune() {
+	now := m.now()
+	m.mu.Lock()
+	expired := m.pruneLocked(now)
+	m.mu.Unlock()
+	m.notifyExpired(expired, now)
+}
+
+// pruneLocked 清除now时已过期的会话, 返回其代理. 需持有mu
+func (m *Manager) pruneLocked(now time.Time) []string {
+	m.lastPrune = now
+	var expired []string
+	for id, s := range m.sessions {
+		if s.fetching == nil && now.Sub(s.assigned) >= m.maxLifetime {
+			expired = append(expired, s.proxy)
+			delete(m.sessions, id)
+		}
+	}
+	return expired
+}
+
+// notifyExpired 通知过期会话的代理已丢弃, 不持有mu调用
+func (m *Manager) notifyExpired(proxies []string, now time.Time) {
+	for _, proxy := range proxies {
+		m.observer.OnDiscarded(&event.DiscardedEvent{Proxy: proxy, Reason: event.DiscardExpired, Time: now})
+	}
+}
+
+func (m *Manager) fetch(ctx context.Context) (string, error) {
+	var (
+		proxies []string
+		err     error
+	)
+	if m.checked {
+		proxies, err = m.adapter.GetCheckedProxiesSync(ctx, 1, true)
+	} else {
+		proxies, err = m.adapter.GetProxiesSync(ctx, 1, true)
+	}
+	if len(proxies) == 0 {
+		if err != nil {
+			return "", err
+		}
+		return "", errors.New("没有可用的代理")
+	}
+	if err != nil {
+		// 出错时返回的代理照常使用, 见adapter.ProxyVendorAdapter
+		m.logger.Warn(fmt.Sprintf("[Session] 获取代理未完成, 使用已获取的代理. %v", err))
+	}
+	return proxies[0], nil
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/proxy/budget"
+	"github.com/zx106kg/go-proxy/test"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager_GetProxyForSession(t *testing.T) {
+	convey.Convey("GetProxyForSession", t, func() {
+		var discarded []event.DiscardReason
+		a := test.NewSequenceAdapter()
+		m := NewManager(&Config{
+			Adapter:     a,
+			MaxLifetime: time.Minute,
+			Observer: &event.Funcs{Discarded: func(e *event.DiscardedEvent) {
+				discarded = append(discarded, e.Reason)
+			}},
+		})
+		now := time.Now()
+		m.now = func() time.Time { return now }
+
+		convey.Convey("Same session gets the same proxy, other sessions rotate.", func() {
+			p1, _ := m.GetProxyForSession(context.TODO(), "a")
+			p2, _ := m.GetProxyForSession(context.TODO(), "a")
+			p3, _ := m.GetProxyForSession(context.TODO(), "b")
+			convey.So(p1, convey.ShouldEqual, p2)
+			convey.So(p3, convey.ShouldNotEqual, p1)
+		})
+
+		convey.Convey("Session is reassigned after max lifetime.", func() {
+			p1, _ := m.GetProxyForSession(context.TODO(), "a")
+			now = now.Add(time.Minute)
+			p2, err := m.GetProxyForSession(context.TODO(), "a")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p2, convey.ShouldNotEqual, p1)
+			convey.So(discarded, convey.ShouldResemble, []event.DiscardReason{event.DiscardExpired})
+		})
+
+		convey.Convey("Session is reassigned after failure.", func() {
+			p1, _ := m.GetProxyForSession(context.TODO(), "a")
+			m.ReportFailure("a", "StatusCode=403")
+			p2, _ := m.GetProxyForSession(context.TODO(), "a")
+			convey.So(p2, convey.ShouldNotEqual, p1)
+			convey.So(discarded, convey.ShouldResemble, []event.DiscardReason{event.DiscardBlacklisted})
+		})
+
+		convey.Convey("Prune removes expired sessions.", func() {
+			_, _ = m.GetProxyForSession(context.TODO(), "a")
+			now = now.Add(30 * time.Second)
+			_, _ = m.GetProxyForSession(context.TODO(), "b")
+			now = now.Add(30 * time.Second)
+			m.Prune()
+			convey.So(m.Len(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Expired sessions are pruned on access.", func() {
+			_, _ = m.GetProxyForSession(context.TODO(), "a")
+			now = now.Add(time.Minute)
+			_, _ = m.GetProxyForSession(context.TODO(), "b")
+			convey.So(m.Len(), convey.ShouldEqual, 1)
+			convey.So(discarded, convey.ShouldResemble, []event.DiscardReason{event.DiscardExpired})
+		})
+
+		convey.Convey("Proxies returned with an error are used.", func() {
+			a.Err = &budget.ExceededError{Window: "hourly", Limit: 1, Used: 1}
+			p, err := m.GetProxyForSession(context.TODO(), "a")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(p, convey.ShouldEqual, "http://192.168.0.1:8888")
+		})
+
+		convey.Convey("Concurrent first calls for a session share one fetch.", func() {
+			a.Wait = make(chan struct{})
+			var wg sync.WaitGroup
+			proxies := make([]string, 3)
+			for i := range proxies {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					proxies[i], _ = m.GetProxyForSession(context.TODO(), "a")
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(a.Wait)
+			wg.Wait()
+			convey.So(a.Calls(), convey.ShouldEqual, 1)
+			convey.So(proxies[1], convey.ShouldEqual, proxies[0])
+			convey.So(proxies[2], convey.ShouldEqual, proxies[0])
+		})
+
+		convey.Convey("A session still fetching is not reported as failed.", func() {
+			a.Wait = make(chan struct{})
+			done := make(chan string)
+			go func() {
+				proxy, _ := m.GetProxyForSession(context.TODO(), "a")
+				done <- proxy
+			}()
+			time.Sleep(20 * time.Millisecond)
+			m.ReportFailure("a", "StatusCode=403")
+			close(a.Wait)
+			proxy := <-done
+			convey.So(discarded, convey.ShouldBeEmpty)
+			p, _ := m.GetProxyForSession(context.TODO(), "a")
+			convey.So(p, convey.ShouldEqual, proxy)
+		})
+	})
+}
@@ -0,0 +1,439 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/logger"
+	"github.com/zx106kg/go-proxy/logger/console"
+	"github.com/zx106kg/go-proxy/proxy/adapter"
+	"github.com/zx106kg/go-proxy/proxy/cooldown"
+	"github.com/zx106kg/go-proxy/proxy/selector"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RoundTripper 每次请求自动选择代理的http.RoundTripper
+//
+// 代理从adapter批量获取并按Selector选择使用, 连接失败或返回指定状态码的代理会被丢弃,
+// 幂等请求会换一个代理重试. 设置Cooldown时按目标域名冷却代理.
+type RoundTripper struct {
+	adapter          adapter.ProxyVendorAdapter
+	base             *http.Transport
+	checked          bool
+	batchSize        int
+	maxProxies       int
+	maxRetries       int
+	retryStatusCodes map[int]bool
+	auth             auth.Authenticator
+	selector         selector.Selector
+	cooldown         *cooldown.Tracker
+	observer         event.Observer
+	logger           logger.Logger
+
+	mu      sync.Mutex
+	proxies []string
+	// refilling 正在获取代理时不为空, 获取结束后关闭
+	refilling chan struct{}
+}
+
+type Config struct {
+	Adapter adapter.ProxyVendorAdapter
+	// Base 发起请求的Transport, 其Proxy会被覆盖. 为空时使用http.DefaultTransport的副本
+	Base *http.Transport
+	// Checked 是否只使用已检查连通性的代理
+	Checked bool
+	// BatchSize 每次从adapter获取的代理数量, 默认10
+	BatchSize int
+	// MaxProxies 持有代理数量上限, 默认BatchSize的10倍. 持有的代理对某个域名都在冷却中时, 未达到上限则获取新的一批,
+	// 达到上限后等待最早结束冷却的代理, 直到请求的ctx结束
+	MaxProxies int
+	// MaxRetries 幂等请求最大重试次数, 默认2, 小于0时不重试
+	MaxRetries int
+	// RetryStatusCodes 视为代理失败并触发重试的状态码, 默认403, 429
+	RetryStatusCodes []int
+	// Authenticator 代理认证, 为空且Adapter实现了adapter.Authenticated时使用Adapter的配置
+	Authenticator auth.Authenticator
+	// Selector 代理选择策略, 默认轮流选择
+	Selector selector.Selector
+	// Cooldown 按目标域名冷却代理, 为空时不冷却. 设置后返回RetryStatusCodes的代理只对该域名封禁, 不再全局丢弃
+	Cooldown *cooldown.Tracker
+	Observer event.Observer
+	Logger   logger.Logger
+}
+
+type proxyKey struct{}
+
+// NewRoundTripper 创建RoundTripper
+func NewRoundTripper(config *Config) *RoundTripper {
+	base := config.Base
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		base = base.Clone()
+	}
+	base.Proxy = proxyFromContext
+	authenticator := config.Authenticator
+	if a, ok := config.Adapter.(adapter.Authenticated); ok && authenticator == nil {
+		authenticator = a.Authenticator()
+	}
+	auth.ApplyTransport(base, authenticator)
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	maxProxies := config.MaxProxies
+	if maxProxies <= 0 {
+		maxProxies = batchSize * 10
+	}
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 2
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+	codes := config.RetryStatusCodes
+	if codes == nil {
+		codes = []int{http.StatusForbidden, http.StatusTooManyRequests}
+	}
+	retryStatusCodes := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		retryStatusCodes[code] = true
+	}
+	sel := config.Selector
+	if sel == nil {
+		sel = selector.NewRoundRobin()
+	}
+	observer := config.Observer
+	if observer == nil {
+		observer = event.Nop()
+	}
+	log := config.Logger
+	if log == nil {
+		log = console.NewLogger()
+	}
+	return &RoundTripper{
+		adapter:          config.Adapter,
+		base:             base,
+		checked:          config.Checked,
+		batchSize:        batchSize,
+		maxProxies:       maxProxies,
+		maxRetries:       maxRetries,
+		retryStatusCodes: retryStatusCodes,
+		auth:             authenticator,
+		selector:         sel,
+		cooldown:         config.Cooldown,
+		observer:         observer,
+		logger:           log,
+	}
+}
+
+// RoundTrip 实现http.RoundTripper
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if isIdempotent(req) {
+		retries = rt.maxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		proxy, err := rt.pick(req.Context(), req.URL.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		outReq, err := rewind(req, attempt)
+		if err != nil {
+			rt.abort(proxy, req.URL.Hostname(), err)
+			return nil, err
+		}
+		outReq = outReq.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy))
+		if rt.auth != nil {
+			outReq, err = rt.authorize(outReq, proxy)
+			if err != nil {
+				rt.abort(proxy, req.URL.Hostname(), err)
+				return nil, err
+			}
+		}
+		start := time.Now()
+		resp, err := rt.base.RoundTrip(outReq)
+		if err != nil {
+			rt.done(proxy, start, nil, err)
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			rt.drop(proxy, err.Error())
+			if attempt >= retries {
+				return nil, err
+			}
+			continue
+		}
+		if rt.retryStatusCodes[resp.StatusCode] {
+			rt.done(proxy, start, resp, nil)
+			if rt.cooldown != nil {
+				rt.block(proxy, req.URL.Hostname(), resp.StatusCode)
+			} else {
+				rt.drop(proxy, fmt.Sprintf("StatusCode=%d", resp.StatusCode))
+			}
+			if attempt < retries {
+				_ = resp.Body.Close()
+				continue
+			}
+			return resp, nil
+		}
+		rt.track(proxy, start, resp)
+		return resp, nil
+	}
+}
+
+// CloseIdleConnections 关闭所有空闲连接
+func (rt *RoundTripper) CloseIdleConnections() {
+	rt.base.CloseIdleConnections()
+}
+
+// pick 按Selector选择一个可用于domain的代理, 无可用代理时从adapter获取
+//
+// 持有的代理都在冷却中时, 未达到MaxProxies则获取一批新代理, 否则等待最早结束冷却的代理
+func (rt *RoundTripper) pick(ctx context.Context, domain string) (string, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	refilled := false
+	for {
+		if len(rt.proxies) == 0 {
+			if err := rt.refill(ctx); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if rt.cooldown == nil {
+			return rt.selector.Select(rt.proxies)
+		}
+		if available := rt.cooldown.Available(domain, rt.proxies); len(available) > 0 {
+			proxy, err := rt.selector.Select(available)
+			if err != nil {
+				return "", err
+			}
+			rt.cooldown.Use(domain, proxy)
+			return proxy, nil
+		}
+		if !refilled && len(rt.proxies) < rt.maxProxies {
+			// 每次选择最多获取一批, adapter只返回已持有的代理时改为等待冷却
+			refilled = true
+			if err := rt.refill(ctx); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err := rt.waitCooldown(ctx, domain); err != nil {
+			return "", err
+		}
+	}
+}
+
+// waitCooldown 等待持有的代理中最早对domain结束冷却的一个. 需持有mu, 等待期间释放mu
+func (rt *RoundTripper) waitCooldown(ctx context.Context, domain string) error {
+	wait := rt.cooldown.WaitTime(domain, rt.proxies)
+	if wait <= 0 {
+		return nil
+	}
+	rt.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		rt.mu.Lock()
+		return fmt.Errorf("等待代理冷却结束. domain=%s, %w", domain, ctx.Err())
+	}
+	rt.mu.Lock()
+	return nil
+}
+
+// refill 从adapter获取一批代理, 已持有代理时只加入新的代理, 总数不超过MaxProxies.
+// 需持有mu, 请求供应商期间释放mu. 其他调用正在获取时只等待其完成, 不重复获取
+func (rt *RoundTripper) refill(ctx context.Context) error {
+	if refilling := rt.refilling; refilling != nil {
+		rt.mu.Unlock()
+		select {
+		case <-refilling:
+		case <-ctx.Done():
+			rt.mu.Lock()
+			return ctx.Err()
+		}
+		rt.mu.Lock()
+		return nil
+	}
+	refilling := make(chan struct{})
+	rt.refilling = refilling
+	rt.mu.Unlock()
+	var (
+		proxies []string
+		err     error
+	)
+	if rt.checked {
+		proxies, err = rt.adapter.GetCheckedProxiesSync(ctx, rt.batchSize, true)
+	} else {
+		proxies, err = rt.adapter.GetProxiesSync(ctx, rt.batchSize, true)
+	}
+	rt.mu.Lock()
+	rt.refilling = nil
+	close(refilling)
+	if err != nil {
+		// 出错时返回的代理照常使用, 见adapter.ProxyVendorAdapter
+		if len(proxies) == 0 {
+			return err
+		}
+		rt.logger.Warn(fmt.Sprintf("[RoundTripper] 获取代理未完成, 使用已获取的%d个代理. %v", len(proxies), err))
+	}
+	if len(proxies) == 0 {
+		return errors.New("没有可用的代理")
+	}
+	if len(rt.proxies) == 0 {
+		rt.proxies = proxies
+		return nil
+	}
+	for _, proxy := range proxies {
+		if len(rt.proxies) >= rt.maxProxies {
+			break
+		}
+		if !contains(rt.proxies, proxy) {
+			rt.proxies = append(rt.proxies, proxy)
+		}
+	}
+	return nil
+}
+
+// done 通知Selector请求结束, 返回需要重试的状态码视为失败
+func (rt *RoundTripper) done(proxy string, start time.Time, resp *http.Response, err error) {
+	if err == nil && rt.retryStatusCodes[resp.StatusCode] {
+		err = fmt.Errorf("StatusCode=%d", resp.StatusCode)
+	}
+	rt.selector.Done(proxy, time.Since(start), err)
+}
+
+// track 响应body读完或关闭时再通知Selector请求结束, 使耗时包含读取body. 没有body或协议升级的响应立即结束
+func (rt *RoundTripper) track(proxy string, start time.Time, resp *http.Response) {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		rt.done(proxy, start, resp, nil)
+		return
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func(err error) {
+		rt.done(proxy, start, resp, err)
+	}}
+}
+
+// trackedBody 第一次读到EOF, 读取出错或关闭时调用done
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func(err error)
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		result := err
+		if err == io.EOF {
+			result = nil
+		}
+		b.once.Do(func() { b.done(result) })
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	// 未读完就关闭视为正常结束
+	b.once.Do(func() { b.done(nil) })
+	return err
+}
+
+// abort 选中代理后请求未发出, 结束Selector的计数并撤销冷却
+func (rt *RoundTripper) abort(proxy string, domain string, err error) {
+	rt.selector.Done(proxy, 0, err)
+	if rt.cooldown != nil {
+		rt.cooldown.Cancel(domain, proxy)
+	}
+}
+
+// drop 丢弃失败的代理
+func (rt *RoundTripper) drop(proxy string, detail string) {
+	rt.mu.Lock()
+	for i, p := range rt.proxies {
+		if p == proxy {
+			rt.proxies = append(rt.proxies[:i], rt.proxies[i+1:]...)
+			break
+		}
+	}
+	rt.mu.Unlock()
+	rt.selector.Forget(proxy)
+	if rt.cooldown != nil {
+		rt.cooldown.Forget(proxy)
+	}
+	rt.logger.Warn(fmt.Sprintf("[RoundTripper] 代理请求失败, 已丢弃. proxy=%s, %s", proxy, detail))
+	rt.observer.OnDiscarded(&event.DiscardedEvent{Proxy: proxy, Reason: event.DiscardBlacklisted, Detail: detail, Time: time.Now()})
+}
+
+// block 代理被domain封禁, 仍可用于其他域名
+func (rt *RoundTripper) block(proxy string, domain string, statusCode int) {
+	rt.cooldown.Block(domain, proxy)
+	rt.logger.Warn(fmt.Sprintf("[RoundTripper] 代理被目标域名封禁. proxy=%s, domain=%s, StatusCode=%d", proxy, domain, statusCode))
+}
+
+// authorize 复制请求并添加代理认证头
+func (rt *RoundTripper) authorize(req *http.Request, proxy string) (*http.Request, error) {
+	urlProxy, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	outReq := req.Clone(req.Context())
+	if err := auth.ApplyRequest(outReq, urlProxy, rt.auth); err != nil {
+		return nil, err
+	}
+	return outReq, nil
+}
+
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	proxy, _ := req.Context().Value(proxyKey{}).(string)
+	if proxy == "" {
+		return nil, nil
+	}
+	return url.Parse(proxy)
+}
+
+func contains(proxies []string, proxy string) bool {
+	for _, p := range proxies {
+		if p == proxy {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdempotent 请求是否可以安全重试
+func isIdempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// rewind 重试时重新生成请求body
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	outReq := req.Clone(req.Context())
+	outReq.Body = body
+	return outReq, nil
+}
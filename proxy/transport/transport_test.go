@@ -0,0 +1,328 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/proxy/budget"
+	"github.com/zx106kg/go-proxy/proxy/cooldown"
+	"github.com/zx106kg/go-proxy/proxy/selector"
+	"github.com/zx106kg/go-proxy/test"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newProxyServer 创建一个直接返回指定状态码的代理服务
+func newProxyServer(statusCode int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+		_, _ = io.WriteString(w, body)
+	}))
+}
+
+func TestRoundTripper_RoundTrip(t *testing.T) {
+	convey.Convey("RoundTrip", t, func() {
+		banned := newProxyServer(http.StatusForbidden, "banned")
+		defer banned.Close()
+		good := newProxyServer(http.StatusOK, "ok")
+		defer good.Close()
+
+		convey.Convey("Retry through another proxy on connection error and 403.", func() {
+			a := test.NewQueueAdapter("http://127.0.0.1:1", banned.URL, good.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 3})
+			client := &http.Client{Transport: rt}
+			resp, err := client.Get("http://example.com/")
+			convey.So(err, convey.ShouldBeNil)
+			buf, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			convey.So(string(buf), convey.ShouldEqual, "ok")
+			convey.So(rt.proxies, convey.ShouldResemble, []string{good.URL})
+		})
+
+		convey.Convey("Non-idempotent requests are not retried.", func() {
+			a := test.NewQueueAdapter(banned.URL, good.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2})
+			req, _ := http.NewRequest(http.MethodPost, "http://example.com/", io.NopCloser(strings.NewReader("a=1")))
+			resp, err := rt.RoundTrip(req)
+			convey.So(err, convey.ShouldBeNil)
+			_ = resp.Body.Close()
+			convey.So(resp.StatusCode, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(rt.proxies, convey.ShouldResemble, []string{good.URL})
+		})
+
+		convey.Convey("Fetch a new batch when all proxies are dropped.", func() {
+			a := test.NewQueueAdapter(banned.URL, good.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1})
+			resp, err := rt.RoundTrip(newGetRequest())
+			convey.So(err, convey.ShouldBeNil)
+			_ = resp.Body.Close()
+			convey.So(resp.StatusCode, convey.ShouldEqual, http.StatusOK)
+			convey.So(a.Calls(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Return the last response when retries are exhausted.", func() {
+			a := test.NewQueueAdapter(banned.URL, banned.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2, MaxRetries: 1})
+			resp, err := rt.RoundTrip(newGetRequest())
+			convey.So(err, convey.ShouldBeNil)
+			_ = resp.Body.Close()
+			convey.So(resp.StatusCode, convey.ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+
+func newGetRequest() *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	return req
+}
+
+func TestRoundTripper_Refill(t *testing.T) {
+	convey.Convey("Refill", t, func() {
+		server := newProxyServer(http.StatusOK, "ok")
+		defer server.Close()
+
+		convey.Convey("Concurrent requests share one fetch without holding the lock.", func() {
+			a := test.NewQueueAdapter(server.URL, server.URL)
+			a.Wait = make(chan struct{})
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1})
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					resp, err := rt.RoundTrip(newGetRequest())
+					if err == nil {
+						_ = resp.Body.Close()
+					}
+					errs[i] = err
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			// 请求供应商期间不持有锁
+			locked := make(chan struct{})
+			go func() {
+				rt.mu.Lock()
+				rt.mu.Unlock()
+				close(locked)
+			}()
+			free := false
+			select {
+			case <-locked:
+				free = true
+			case <-time.After(time.Second):
+			}
+			convey.So(free, convey.ShouldBeTrue)
+			close(a.Wait)
+			wg.Wait()
+			convey.So(errs, convey.ShouldResemble, []error{nil, nil})
+			convey.So(a.Calls(), convey.ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRoundTripper_Authenticator(t *testing.T) {
+	convey.Convey("Authenticator", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Header.Get("Proxy-Authorization"))
+		}))
+		defer server.Close()
+
+		a := test.NewQueueAdapter(server.URL)
+		rt := NewRoundTripper(&Config{Adapter: a, Authenticator: &auth.Token{Token: "abc"}})
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		resp, err := (&http.Client{Transport: rt}).Do(req)
+		convey.So(err, convey.ShouldBeNil)
+		buf, _ := io.ReadAll(resp.Body)
+		convey.So(string(buf), convey.ShouldEqual, "Bearer abc")
+		// 原请求不被修改
+		convey.So(req.Header.Get("Proxy-Authorization"), convey.ShouldEqual, "")
+	})
+}
+
+func TestRoundTripper_Selector(t *testing.T) {
+	convey.Convey("Selector", t, func() {
+		good := newProxyServer(http.StatusOK, "ok")
+		defer good.Close()
+		banned := newProxyServer(http.StatusForbidden, "banned")
+		defer banned.Close()
+
+		s := selector.NewLeastInFlight()
+		a := test.NewQueueAdapter(banned.URL, good.URL)
+		rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2, Selector: s})
+		resp, err := rt.RoundTrip(newGetRequest())
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(resp.StatusCode, convey.ShouldEqual, http.StatusOK)
+		// 读完body前请求仍在进行中
+		convey.So(s.InFlight(good.URL), convey.ShouldEqual, 1)
+		_, _ = io.ReadAll(resp.Body)
+		convey.So(s.InFlight(good.URL), convey.ShouldEqual, 0)
+		_ = resp.Body.Close()
+		// 请求结束后进行中的请求数归零
+		convey.So(s.InFlight(good.URL), convey.ShouldEqual, 0)
+		convey.So(s.InFlight(banned.URL), convey.ShouldEqual, 0)
+	})
+}
+
+func TestRoundTripper_Budget(t *testing.T) {
+	convey.Convey("Budget", t, func() {
+		server := newProxyServer(http.StatusOK, "ok")
+		defer server.Close()
+
+		convey.Convey("Proxies returned with a budget error are used.", func() {
+			a := test.NewQueueAdapter(server.URL)
+			a.Err = &budget.ExceededError{Window: "hourly", Limit: 1, Used: 1}
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2})
+			resp, err := rt.RoundTrip(newGetRequest())
+			convey.So(err, convey.ShouldBeNil)
+			_ = resp.Body.Close()
+			convey.So(rt.proxies, convey.ShouldResemble, []string{server.URL})
+		})
+
+		convey.Convey("Budget error is returned when no proxy arrives.", func() {
+			a := test.NewQueueAdapter()
+			a.Err = &budget.ExceededError{Window: "hourly", Limit: 1, Used: 1}
+			rt := NewRoundTripper(&Config{Adapter: a})
+			_, err := rt.RoundTrip(newGetRequest())
+			convey.So(errors.Is(err, budget.ErrExceeded), convey.ShouldBeTrue)
+		})
+	})
+}
+
+func TestRoundTripper_Abort(t *testing.T) {
+	convey.Convey("Abort", t, func() {
+		server := newProxyServer(http.StatusOK, "ok")
+		defer server.Close()
+
+		s := selector.NewLeastInFlight()
+		tracker := cooldown.NewTracker(&cooldown.Config{Cooldown: time.Minute})
+		a := test.NewQueueAdapter(server.URL)
+		failing := auth.Func(func(*url.URL, string) (http.Header, error) {
+			return nil, errors.New("sign failed")
+		})
+		rt := NewRoundTripper(&Config{Adapter: a, Authenticator: failing, Selector: s, Cooldown: tracker})
+		_, err := rt.RoundTrip(newGetRequest())
+		convey.So(err, convey.ShouldBeError)
+		// 请求未发出, 不计入进行中的请求, 也不进入冷却
+		convey.So(s.InFlight(server.URL), convey.ShouldEqual, 0)
+		convey.So(tracker.Available("example.com", []string{server.URL}), convey.ShouldResemble, []string{server.URL})
+	})
+}
+
+func TestRoundTripper_Cooldown(t *testing.T) {
+	convey.Convey("Cooldown", t, func() {
+		// 封禁a.com的代理
+		blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Hostname() == "a.com" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			_, _ = io.WriteString(w, "blocked")
+		}))
+		defer blocked.Close()
+		good := newProxyServer(http.StatusOK, "good")
+		defer good.Close()
+		get := func(rt *RoundTripper, target string) (int, string, error) {
+			req, _ := http.NewRequest(http.MethodGet, target, nil)
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				return 0, "", err
+			}
+			buf, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			return resp.StatusCode, string(buf), nil
+		}
+
+		convey.Convey("A proxy is not reused for the same domain while cooling down.", func() {
+			a := test.NewQueueAdapter(good.URL, blocked.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2, Cooldown: cooldown.NewTracker(&cooldown.Config{Cooldown: time.Minute})})
+			_, body, _ := get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "good")
+			_, body, _ = get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "blocked")
+			_, body, _ = get(rt, "http://c.com/")
+			convey.So(body, convey.ShouldEqual, "good")
+
+			_, _, err := get(rt, "http://b.com/")
+			convey.So(err, convey.ShouldBeError)
+		})
+
+		convey.Convey("Cooling proxies trigger a new batch under MaxProxies.", func() {
+			a := test.NewQueueAdapter(good.URL, blocked.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1, Cooldown: cooldown.NewTracker(&cooldown.Config{Cooldown: time.Minute})})
+			_, body, _ := get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "good")
+			_, body, err := get(rt, "http://b.com/")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(body, convey.ShouldEqual, "blocked")
+			convey.So(a.Calls(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("Wait for the earliest cooldown at MaxProxies.", func() {
+			a := test.NewQueueAdapter(good.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1, MaxProxies: 1, Cooldown: cooldown.NewTracker(&cooldown.Config{Cooldown: 50 * time.Millisecond})})
+			_, body, _ := get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "good")
+			start := time.Now()
+			_, body, err := get(rt, "http://b.com/")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(body, convey.ShouldEqual, "good")
+			convey.So(time.Since(start), convey.ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+			convey.So(a.Calls(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("Waiting for a cooldown stops when ctx is done.", func() {
+			a := test.NewQueueAdapter(good.URL)
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1, MaxProxies: 1, Cooldown: cooldown.NewTracker(&cooldown.Config{Cooldown: time.Minute})})
+			_, body, _ := get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "good")
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://b.com/", nil)
+			_, err := rt.RoundTrip(req)
+			convey.So(errors.Is(err, context.DeadlineExceeded), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Blocked proxies trigger a new batch up to MaxProxies.", func() {
+			a := test.NewQueueAdapter(blocked.URL, good.URL)
+			tracker := cooldown.NewTracker(&cooldown.Config{Cooldown: time.Minute})
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 1, MaxProxies: 1, MaxRetries: -1, Cooldown: tracker})
+			code, _, _ := get(rt, "http://a.com/")
+			convey.So(code, convey.ShouldEqual, http.StatusForbidden)
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://a.com/", nil)
+			_, err := rt.RoundTrip(req)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(a.Calls(), convey.ShouldEqual, 1)
+
+			rt.maxProxies = 2
+			_, body, err := get(rt, "http://a.com/")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(body, convey.ShouldEqual, "good")
+			convey.So(a.Calls(), convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("A blocked proxy stays available for other domains.", func() {
+			a := test.NewQueueAdapter(blocked.URL, good.URL)
+			tracker := cooldown.NewTracker(&cooldown.Config{Cooldown: time.Millisecond})
+			rt := NewRoundTripper(&Config{Adapter: a, BatchSize: 2, Cooldown: tracker})
+			code, body, err := get(rt, "http://a.com/")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(code, convey.ShouldEqual, http.StatusOK)
+			convey.So(body, convey.ShouldEqual, "good")
+			convey.So(tracker.Blocked("a.com", blocked.URL), convey.ShouldBeTrue)
+			convey.So(rt.proxies, convey.ShouldResemble, []string{blocked.URL, good.URL})
+
+			time.Sleep(5 * time.Millisecond)
+			_, body, _ = get(rt, "http://b.com/")
+			convey.So(body, convey.ShouldEqual, "blocked")
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Adapter 测试用的adapter.ProxyVendorAdapter, 并发安全
+//
+// 由NewSequenceAdapter, NewFixedAdapter, NewQueueAdapter创建, 决定每次返回哪些代理.
+// Err不为空时与代理一起返回, Limit大于0时每次最多返回Limit个代理, Wait不为空时等待其关闭后返回.
+type Adapter struct {
+	Err   error
+	Limit int
+	Wait  chan struct{}
+
+	mu     sync.Mutex
+	next   func(count int) []string
+	calls  int
+	served int
+}
+
+// NewSequenceAdapter 每次返回新的代理, 依次为http://192.168.0.1:8888, http://192.168.0.2:8888...
+func NewSequenceAdapter() *Adapter {
+	n := 0
+	return &Adapter{next: func(count int) []string {
+		proxies := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			n++
+			proxies = append(proxies, fmt.Sprintf("http://192.168.0.%d:8888", n))
+		}
+		return proxies
+	}}
+}
+
+// NewFixedAdapter 每次返回全部proxies, 不受count限制
+func NewFixedAdapter(proxies ...string) *Adapter {
+	return &Adapter{next: func(int) []string {
+		return append([]string(nil), proxies...)
+	}}
+}
+
+// NewQueueAdapter 按顺序返回proxies, 每个代理只返回一次, 用完后返回空
+func NewQueueAdapter(proxies ...string) *Adapter {
+	return &Adapter{next: func(count int) []string {
+		if count > len(proxies) {
+			count = len(proxies)
+		}
+		batch := proxies[:count]
+		proxies = proxies[count:]
+		return batch
+	}}
+}
+
+// Calls GetProxy及各批量获取方法被调用的次数
+func (a *Adapter) Calls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls
+}
+
+// Served 已返回的代理总数
+func (a *Adapter) Served() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.served
+}
+
+func (a *Adapter) GetProxy(ctx context.Context, exitWhenError bool) (string, error) {
+	proxies, err := a.GetProxiesSync(ctx, 1, exitWhenError)
+	if err != nil {
+		return "", err
+	}
+	if len(proxies) == 0 {
+		return "", errors.New("没有可用的代理")
+	}
+	return proxies[0], nil
+}
+
+func (a *Adapter) GetProxiesSync(_ context.Context, count int, _ bool) ([]string, error) {
+	a.mu.Lock()
+	a.calls++
+	wait := a.Wait
+	a.mu.Unlock()
+	if wait != nil {
+		<-wait
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Limit > 0 && count > a.Limit {
+		count = a.Limit
+	}
+	proxies := a.next(count)
+	a.served += len(proxies)
+	return proxies, a.Err
+}
+
+func (a *Adapter) GetCheckedProxiesSync(ctx context.Context, count int, exitWhenError bool) ([]string, error) {
+	return a.GetProxiesSync(ctx, count, exitWhenError)
+}
+
+func (a *Adapter) GetProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chan string, chan error) {
+	chProxy, chErr := make(chan string, count), make(chan error, 1)
+	go func() {
+		defer close(chProxy)
+		proxies, err := a.GetProxiesSync(ctx, count, exitWhenError)
+		for _, proxy := range proxies {
+			chProxy <- proxy
+		}
+		if err != nil {
+			chErr <- err
+		}
+	}()
+	return chProxy, chErr
+}
+
+func (a *Adapter) GetCheckedProxiesAsync(ctx context.Context, count int, exitWhenError bool) (chan string, chan error) {
+	return a.GetProxiesAsync(ctx, count, exitWhenError)
+}
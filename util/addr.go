@@ -0,0 +1,67 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SplitProxyAddr 拆分并校验host:port形式的代理地址
+//
+// host支持IPv4, 方括号包裹的IPv6以及域名, port范围为1-65535
+func SplitProxyAddr(addr string) (host string, port int, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, errors.New("format is incorrect")
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, errors.New("port is out of range")
+	}
+	if strings.HasPrefix(addr, "[") {
+		// 方括号中只允许IPv6
+		if ip := net.ParseIP(host); ip == nil || !strings.Contains(host, ":") {
+			return "", 0, errors.New("format is incorrect")
+		}
+		return host, port, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			// IPv6必须使用方括号
+			return "", 0, errors.New("format is incorrect")
+		}
+		return host, port, nil
+	}
+	if !isValidHostname(host) {
+		return "", 0, errors.New("format is incorrect")
+	}
+	return host, port, nil
+}
+
+// IsValidProxyAddr 是否为合法的host:port代理地址
+func IsValidProxyAddr(addr string) bool {
+	_, _, err := SplitProxyAddr(addr)
+	return err == nil
+}
+
+// isValidHostname 校验域名, 顶级域名不能为纯数字, 避免把非法IPv4当作域名
+func isValidHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	labels := strings.Split(host, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"context"
+	"strings"
+)
+
+// AnonymityLevel 代理匿名级别, 数值越大越匿名
+type AnonymityLevel int
+
+const (
+	// AnonymityUnknown 未检测
+	AnonymityUnknown AnonymityLevel = iota
+	// AnonymityTransparent 透明代理, 目标能看到真实IP
+	AnonymityTransparent
+	// AnonymityAnonymous 普通匿名代理, 目标能看出使用了代理, 但看不到真实IP
+	AnonymityAnonymous
+	// AnonymityElite 高匿代理, 目标看不出使用了代理
+	AnonymityElite
+)
+
+func (l AnonymityLevel) String() string {
+	switch l {
+	case AnonymityTransparent:
+		return "transparent"
+	case AnonymityAnonymous:
+		return "anonymous"
+	case AnonymityElite:
+		return "elite"
+	}
+	return "unknown"
+}
+
+// proxyHeaders 代理可能添加的请求头
+var proxyHeaders = []string{
+	"via",
+	"x-forwarded-for",
+	"x-forwarded-host",
+	"x-forwarded-proto",
+	"x-real-ip",
+	"forwarded",
+	"proxy-connection",
+	"x-proxy-id",
+	"proxy-agent",
+	"client-ip",
+	"x-client-ip",
+	"x-bluecoat-via",
+}
+
+// ClassifyAnonymity 根据回显接口返回的内容判断匿名级别
+//
+// body 回显接口返回的请求头, 格式不限, 如httpbin.org/get返回的JSON
+//
+// realIp 本机真实出口IP
+func ClassifyAnonymity(body string, realIp string) AnonymityLevel {
+	if realIp != "" && containsIp(body, realIp) {
+		return AnonymityTransparent
+	}
+	lower := strings.ToLower(body)
+	for _, header := range proxyHeaders {
+		if strings.Contains(lower, `"`+header+`"`) || strings.Contains(lower, header+":") {
+			return AnonymityAnonymous
+		}
+	}
+	return AnonymityElite
+}
+
+// containsIp 判断body中是否包含完整的ip, 前后紧邻IP字符时不算, 如203.0.113.70不包含203.0.113.7
+func containsIp(body string, ip string) bool {
+	for offset := 0; ; {
+		i := strings.Index(body[offset:], ip)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(ip)
+		if (start == 0 || !isIpChar(body[start-1])) && (end == len(body) || !isIpChar(body[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isIpChar(c byte) bool {
+	return c == '.' || c == ':' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// GetProxyAnonymity 通过代理请求回显接口, 判断代理匿名级别
+//
+// echoUrl 返回请求头的接口
+func GetProxyAnonymity(ctx context.Context, proxy string, echoUrl string, realIp string) (level AnonymityLevel, err error) {
+	c := &Checker{}
+	defer c.Close()
+	return c.getAnonymity(ctx, proxy, echoUrl, realIp)
+}
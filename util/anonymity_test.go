@@ -0,0 +1,117 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClassifyAnonymity(t *testing.T) {
+	convey.Convey("ClassifyAnonymity", t, func() {
+		convey.Convey("Real ip is leaked.", func() {
+			body := `{"headers": {"X-Forwarded-For": "203.0.113.7"}, "origin": "1.0.0.1"}`
+			convey.So(ClassifyAnonymity(body, "203.0.113.7"), convey.ShouldEqual, AnonymityTransparent)
+		})
+
+		convey.Convey("Similar ip is not a leak.", func() {
+			body := `{"headers": {}, "origin": "203.0.113.70"}`
+			convey.So(ClassifyAnonymity(body, "203.0.113.7"), convey.ShouldEqual, AnonymityElite)
+		})
+
+		convey.Convey("Later exact ip is a leak.", func() {
+			body := `{"origin": "203.0.113.70, 203.0.113.7"}`
+			convey.So(ClassifyAnonymity(body, "203.0.113.7"), convey.ShouldEqual, AnonymityTransparent)
+			convey.So(ClassifyAnonymity("203.0.113.7", "203.0.113.7"), convey.ShouldEqual, AnonymityTransparent)
+		})
+
+		convey.Convey("Proxy headers are present.", func() {
+			body := "Host: example.com\r\nVia: 1.1 squid\r\n"
+			convey.So(ClassifyAnonymity(body, "203.0.113.7"), convey.ShouldEqual, AnonymityAnonymous)
+		})
+
+		convey.Convey("Level names.", func() {
+			convey.So(AnonymityElite.String(), convey.ShouldEqual, "elite")
+			convey.So(AnonymityUnknown.String(), convey.ShouldEqual, "unknown")
+		})
+	})
+}
+
+// newForwardProxy 创建转发到echo服务的代理, 转发时添加headers, 其他目标直接返回200
+func newForwardProxy(echoHost string, headers map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != echoHost {
+			return
+		}
+		req, _ := http.NewRequest(r.Method, r.URL.String(), nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+}
+
+func TestChecker_Anonymity(t *testing.T) {
+	convey.Convey("Anonymity", t, func() {
+		echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 模拟代理的出口IP由代理通过X-Test-Origin告知, 不出现在回显的请求头中
+			host := r.Header.Get("X-Test-Origin")
+			r.Header.Del("X-Test-Origin")
+			if host == "" {
+				host, _, _ = net.SplitHostPort(r.RemoteAddr)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"headers": r.Header, "origin": host})
+		}))
+		defer echo.Close()
+		echoUrl, _ := url.Parse(echo.URL)
+		transparent := newForwardProxy(echoUrl.Host, map[string]string{"X-Forwarded-For": "203.0.113.7"})
+		defer transparent.Close()
+		anonymous := newForwardProxy(echoUrl.Host, map[string]string{"Via": "1.1 proxy"})
+		defer anonymous.Close()
+		elite := newForwardProxy(echoUrl.Host, nil)
+		defer elite.Close()
+
+		convey.Convey("Classify proxies.", func() {
+			checker := NewChecker(&CheckerOptions{AnonymityEchoUrl: echo.URL, RealIp: "203.0.113.7"})
+			convey.So(checker.Check(context.TODO(), transparent.URL).Anonymity, convey.ShouldEqual, AnonymityTransparent)
+			convey.So(checker.Check(context.TODO(), anonymous.URL).Anonymity, convey.ShouldEqual, AnonymityAnonymous)
+			convey.So(checker.Check(context.TODO(), elite.URL).Anonymity, convey.ShouldEqual, AnonymityElite)
+		})
+
+		convey.Convey("Filter by min anonymity.", func() {
+			checker := NewChecker(&CheckerOptions{AnonymityEchoUrl: echo.URL, RealIp: "203.0.113.7", MinAnonymity: AnonymityAnonymous})
+			succ, fail := checker.CheckSync(context.TODO(), []string{transparent.URL, anonymous.URL, elite.URL})
+			convey.So(len(succ), convey.ShouldEqual, 2)
+			convey.So(len(fail), convey.ShouldEqual, 1)
+			convey.So(fail[0].Proxy, convey.ShouldEqual, transparent.URL)
+		})
+
+		convey.Convey("Real ip is detected from the echo endpoint.", func() {
+			remote := newForwardProxy(echoUrl.Host, map[string]string{"X-Test-Origin": "198.51.100.1"})
+			defer remote.Close()
+			leaking := newForwardProxy(echoUrl.Host, map[string]string{"X-Test-Origin": "198.51.100.1", "X-Forwarded-For": "127.0.0.1"})
+			defer leaking.Close()
+
+			checker := NewChecker(&CheckerOptions{AnonymityEchoUrl: echo.URL})
+			r := checker.Check(context.TODO(), remote.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(checker.realIp, convey.ShouldEqual, "127.0.0.1")
+			convey.So(r.Anonymity, convey.ShouldEqual, AnonymityElite)
+
+			r = checker.Check(context.TODO(), leaking.URL)
+			convey.So(r.Anonymity, convey.ShouldEqual, AnonymityTransparent)
+		})
+	})
+}
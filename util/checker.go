@@ -0,0 +1,282 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/event"
+	"github.com/zx106kg/go-proxy/geo"
+	"net"
+	"sync"
+	"time"
+)
+
+// CheckerOptions Checker配置
+type CheckerOptions struct {
+	// Authenticator 代理认证, 为空时只使用代理连接串中的用户名密码
+	Authenticator auth.Authenticator
+	// Targets 连通性检查目标, 为空时只检查www.baidu.com
+	Targets []*CheckTarget
+	// Policy 多目标检查的判定策略, 默认AllPolicy
+	Policy ConsensusPolicy
+	// Geo 出口IP地理位置查询, 为空时不查询. 设置时必须同时设置IpEchoUrl
+	Geo geo.Lookup
+	// GeoFilter 按出口IP地理位置过滤, 需要同时设置Geo
+	GeoFilter *geo.Filter
+	// IpEchoUrl 获取出口IP的接口, 查询地理位置时使用
+	IpEchoUrl string
+	// AnonymityEchoUrl 返回请求头的回显接口, 如http://httpbin.org/get. 为空时不检测匿名级别
+	AnonymityEchoUrl string
+	// RealIp 本机真实出口IP, 为空时不经代理请求AnonymityEchoUrl获取
+	RealIp string
+	// MinAnonymity 要求的最低匿名级别
+	MinAnonymity AnonymityLevel
+	// ConnectTarget 检查CONNECT隧道及TLS握手的目标host:port, 如www.baidu.com:443. 为空时不检查
+	ConnectTarget string
+	// TlsConfig CONNECT隧道TLS握手以及请求https目标时使用的配置, 可以设置自定义CA
+	TlsConfig *tls.Config
+	// Timeout 单次请求超时时间, 默认3秒
+	Timeout time.Duration
+	// DialContext 连接代理使用的拨号函数, 为空时使用net.Dialer
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// KeepWarm 检查成功后保留到代理的空闲连接, 通过WarmTransport取出使用. 为false时每次检查后关闭空闲连接
+	KeepWarm bool
+	// WarmTtl 保留的连接超过此时间未取出时关闭, 默认30秒
+	WarmTtl time.Duration
+}
+
+// Checker 可配置的代理检查器
+//
+// 先检查连通性, 再按配置执行其他检查.
+type Checker struct {
+	options CheckerOptions
+	mu      sync.Mutex
+	realIp  string
+
+	tmu        sync.Mutex
+	transports map[string]*proxyTransport
+	now        func() time.Time
+}
+
+// NewChecker 创建Checker, options为空时只检查连通性
+func NewChecker(options *CheckerOptions) *Checker {
+	c := &Checker{}
+	if options != nil {
+		c.options = *options
+	}
+	c.realIp = c.options.RealIp
+	return c
+}
+
+// Check 检查单个代理
+func (c *Checker) Check(ctx context.Context, proxy string) *CheckResult {
+	result := &CheckResult{Proxy: proxy}
+	release := c.hold(proxy)
+	defer func() {
+		release(c.options.KeepWarm && result.Success)
+	}()
+	if err := c.checkConn(ctx, result); err != nil {
+		result.Err = err
+		return result
+	}
+	if c.options.Geo != nil {
+		if err := c.checkGeo(ctx, result); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+	if c.options.ConnectTarget != "" {
+		var err error
+		result.ConnectOk, result.TlsOk, err = c.connect(ctx, proxy, c.options.ConnectTarget)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+	if c.options.AnonymityEchoUrl != "" {
+		if err := c.checkAnonymity(ctx, result); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+	result.Success = true
+	return result
+}
+
+// CheckSync 批量检查代理, 同步返回结果
+//
+// succ为检查成功的结果, fail为检查失败的结果.
+func (c *Checker) CheckSync(ctx context.Context, proxies []string, observers ...event.Observer) (succ, fail []*CheckResult) {
+	ch := make(chan *CheckResult)
+	c.CheckAsync(ctx, proxies, ch, observers...)
+	for range proxies {
+		r := <-ch
+		if r.Success {
+			succ = append(succ, r)
+		} else {
+			fail = append(fail, r)
+		}
+	}
+	return succ, fail
+}
+
+// CheckAsync 批量检查代理, 每个代理检查完成时立刻通过ch返回结果
+func (c *Checker) CheckAsync(ctx context.Context, proxies []string, ch chan *CheckResult, observers ...event.Observer) {
+	observer := event.Multi(observers...)
+	for _, proxy := range proxies {
+		go func(proxy string) {
+			r := c.Check(ctx, proxy)
+			observer.OnChecked(&event.CheckedEvent{Proxy: proxy, Success: r.Success, Err: r.Err, Time: time.Now()})
+			ch <- r
+		}(proxy)
+	}
+}
+
+// checkConn 检查连通性, 配置了Targets时按Policy判定多目标结果
+func (c *Checker) checkConn(ctx context.Context, result *CheckResult) error {
+	start := time.Now()
+	targets := c.options.Targets
+	if len(targets) == 0 && c.isDefaultRequest() {
+		ok, err := CheckProxyConn(ctx, result.Proxy)
+		result.Latency = time.Since(start)
+		if !ok {
+			if err == nil {
+				err = errors.New("代理连通性检查失败")
+			}
+			return err
+		}
+		return nil
+	}
+	if len(targets) == 0 {
+		targets = []*CheckTarget{{Url: defaultCheckTarget}}
+	}
+	result.Targets = c.checkTargets(ctx, result.Proxy, targets)
+	for _, r := range result.Targets {
+		if r.Latency > result.Latency {
+			result.Latency = r.Latency
+		}
+	}
+	policy := c.options.Policy
+	if policy == nil {
+		policy = AllPolicy{}
+	}
+	if !policy.Pass(targets, result.Targets) {
+		return fmt.Errorf("多目标连通性检查未通过. 成功%d/%d", countSuccess(result.Targets), len(result.Targets))
+	}
+	return nil
+}
+
+// isDefaultRequest 是否使用默认的请求方式, 此时连通性检查与CheckProxyConn一致
+func (c *Checker) isDefaultRequest() bool {
+	o := c.options
+	return o.Authenticator == nil && o.TlsConfig == nil && o.Timeout == 0 && o.DialContext == nil && !o.KeepWarm
+}
+
+// clock 返回当前时间, 测试时可替换now
+func (c *Checker) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// timeout 单次请求超时时间
+func (c *Checker) timeout() time.Duration {
+	if c.options.Timeout > 0 {
+		return c.options.Timeout
+	}
+	return 3 * time.Second
+}
+
+// dial 连接代理
+func (c *Checker) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.options.DialContext != nil {
+		return c.options.DialContext(ctx, network, addr)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+// checkGeo 查询出口IP地理位置并过滤
+func (c *Checker) checkGeo(ctx context.Context, result *CheckResult) error {
+	ip, err := c.exitIp(ctx, result.Proxy)
+	if err != nil {
+		return err
+	}
+	result.ExitIp = ip.String()
+	loc, err := c.options.Geo.Lookup(ip)
+	if err != nil && !errors.Is(err, geo.ErrNotFound) {
+		return err
+	}
+	result.Location = loc
+	if ok, reason := c.options.GeoFilter.Match(loc); !ok {
+		return fmt.Errorf("出口IP地理位置不符合要求. ip=%s, %s", result.ExitIp, reason)
+	}
+	return nil
+}
+
+// checkAnonymity 检测匿名级别并过滤
+func (c *Checker) checkAnonymity(ctx context.Context, result *CheckResult) error {
+	realIp, err := c.getRealIp(ctx)
+	if err != nil {
+		return fmt.Errorf("获取本机出口IP失败. %v", err)
+	}
+	level, err := c.getAnonymity(ctx, result.Proxy, c.options.AnonymityEchoUrl, realIp)
+	if err != nil {
+		return err
+	}
+	result.Anonymity = level
+	if level < c.options.MinAnonymity {
+		return fmt.Errorf("代理匿名级别不符合要求. level=%s", level)
+	}
+	return nil
+}
+
+// getRealIp 获取本机真实出口IP, 成功后缓存
+//
+// 请求期间不持有锁, 并发检查时可能重复请求回显接口, 结果相同
+func (c *Checker) getRealIp(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	realIp := c.realIp
+	c.mu.Unlock()
+	if realIp != "" {
+		return realIp, nil
+	}
+	release := c.hold("")
+	defer release(false)
+	resp, err := c.proxyGet(ctx, "", c.options.AnonymityEchoUrl, nil, true)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+	ip := findIp(body)
+	if ip == "" {
+		return "", fmt.Errorf("回显接口返回内容中不包含IP. 原文: %s", body)
+	}
+	c.mu.Lock()
+	c.realIp = ip
+	c.mu.Unlock()
+	return ip, nil
+}
+
+// exitIp 通过IpEchoUrl获取代理出口IP
+//
+// 代理主机IP通常不是出口IP, 未配置IpEchoUrl时返回错误而不是按主机IP查询地理位置
+func (c *Checker) exitIp(ctx context.Context, proxy string) (net.IP, error) {
+	if c.options.IpEchoUrl == "" {
+		return nil, errors.New("查询出口IP地理位置需要设置IpEchoUrl")
+	}
+	ip, err := c.GetExitIp(ctx, proxy, c.options.IpEchoUrl)
+	if err != nil {
+		return nil, err
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("出口IP格式错误. ip=%s", ip)
+	}
+	return parsed, nil
+}
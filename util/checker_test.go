@@ -0,0 +1,137 @@
+package util
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/auth"
+	"github.com/zx106kg/go-proxy/geo"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecker_Check(t *testing.T) {
+	convey.Convey("Checker", t, func() {
+		exitIps := map[string]string{"/us": "1.0.0.1", "/cn": "10.0.0.1"}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 作为代理时, 按目标地址的路径返回出口IP
+			_, _ = io.WriteString(w, exitIps[r.URL.Path])
+		}))
+		defer server.Close()
+		db, _ := geo.LoadCSV(strings.NewReader("1.0.0.0,1.0.0.255,US\n10.0.0.0/8,,CN"))
+
+		convey.Convey("Only connectivity is checked by default.", func() {
+			r := NewChecker(nil).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(r.Location, convey.ShouldBeNil)
+
+			r = NewChecker(nil).Check(context.TODO(), "http://127.0.0.1:1")
+			convey.So(r.Success, convey.ShouldBeFalse)
+			convey.So(r.Err, convey.ShouldBeError)
+		})
+
+		convey.Convey("Filter by exit ip country.", func() {
+			filter := &geo.Filter{AllowCountries: []string{"US"}}
+			r := NewChecker(&CheckerOptions{Geo: db, GeoFilter: filter, IpEchoUrl: "http://echo.local/us"}).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(r.ExitIp, convey.ShouldEqual, "1.0.0.1")
+			convey.So(r.Location.Country, convey.ShouldEqual, "US")
+
+			r = NewChecker(&CheckerOptions{Geo: db, GeoFilter: filter, IpEchoUrl: "http://echo.local/cn"}).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+			convey.So(r.Location.Country, convey.ShouldEqual, "CN")
+		})
+
+		convey.Convey("Authenticator headers are sent to the proxy.", func() {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Proxy-Authorization") != "Bearer abc" {
+					w.WriteHeader(http.StatusProxyAuthRequired)
+				}
+			}))
+			defer tokenServer.Close()
+
+			r := NewChecker(&CheckerOptions{Authenticator: &auth.Token{Token: "abc"}}).Check(context.TODO(), tokenServer.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+
+			r = NewChecker(&CheckerOptions{Authenticator: &auth.Token{Token: "bad"}}).Check(context.TODO(), tokenServer.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Authenticator overrides credentials in proxy url.", func() {
+			var received []string
+			authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Header.Get("Proxy-Authorization"))
+				if r.Method == http.MethodConnect {
+					w.WriteHeader(http.StatusForbidden)
+				}
+			}))
+			defer authServer.Close()
+			proxy := strings.Replace(authServer.URL, "http://", "http://usr:pwd@", 1)
+
+			checker := NewChecker(&CheckerOptions{Authenticator: &auth.Token{Token: "abc"}, ConnectTarget: "example.com:443"})
+			r := checker.Check(context.TODO(), proxy)
+			convey.So(r.ConnectOk, convey.ShouldBeFalse)
+			convey.So(received, convey.ShouldResemble, []string{"Bearer abc", "Bearer abc"})
+
+			received = nil
+			checker = NewChecker(&CheckerOptions{Authenticator: auth.StaticHeader{"X-Key": []string{"v"}}, ConnectTarget: "example.com:443"})
+			checker.Check(context.TODO(), proxy)
+			basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("usr:pwd"))
+			convey.So(received, convey.ShouldResemble, []string{basic, basic})
+		})
+
+		convey.Convey("Geo lookup requires echo url.", func() {
+			r := NewChecker(&CheckerOptions{Geo: db, GeoFilter: &geo.Filter{DenyCountries: []string{"CN"}}}).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+			convey.So(r.Err, convey.ShouldBeError)
+			convey.So(r.ExitIp, convey.ShouldBeEmpty)
+		})
+	})
+}
+
+func TestChecker_CheckSync(t *testing.T) {
+	convey.Convey("CheckSync", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		succ, fail := NewChecker(nil).CheckSync(context.TODO(), []string{server.URL, "http://127.0.0.1:1", server.URL})
+		convey.So(len(succ), convey.ShouldEqual, 2)
+		convey.So(len(fail), convey.ShouldEqual, 1)
+		convey.So(fail[0].Proxy, convey.ShouldEqual, "http://127.0.0.1:1")
+	})
+}
+
+func TestChecker_Options(t *testing.T) {
+	convey.Convey("Request options", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+		}))
+		defer server.Close()
+		serverAddr := strings.TrimPrefix(server.URL, "http://")
+
+		convey.Convey("DialContext redirects proxy connections.", func() {
+			var dialed string
+			c := NewChecker(&CheckerOptions{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialed = addr
+				return (&net.Dialer{}).DialContext(ctx, network, serverAddr)
+			}})
+			r := c.Check(context.TODO(), "http://proxy.local:8080")
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(dialed, convey.ShouldEqual, "proxy.local:8080")
+		})
+
+		convey.Convey("Timeout applies to each request.", func() {
+			targets := []*CheckTarget{{Url: "http://example.com/slow"}}
+			r := NewChecker(&CheckerOptions{Targets: targets, Timeout: 50 * time.Millisecond}).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+			r = NewChecker(&CheckerOptions{Targets: targets, Timeout: time.Second}).Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+		})
+	})
+}
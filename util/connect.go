@@ -0,0 +1,17 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+// CheckProxyConnect 通过代理建立CONNECT隧道, 并与target完成TLS握手
+//
+// target 目标地址host:port, 如www.baidu.com:443
+//
+// tlsConfig 为空时使用默认配置, ServerName为空时取target的host
+//
+// connectOk表示代理是否接受CONNECT, tlsOk表示TLS握手是否成功
+func CheckProxyConnect(ctx context.Context, proxy string, target string, tlsConfig *tls.Config) (connectOk, tlsOk bool, err error) {
+	return NewChecker(&CheckerOptions{TlsConfig: tlsConfig}).connect(ctx, proxy, target)
+}
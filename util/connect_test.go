@@ -0,0 +1,81 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/smartystreets/goconvey/convey"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newConnectProxy 创建支持CONNECT的代理
+func newConnectProxy() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	}))
+}
+
+func TestCheckProxyConnect(t *testing.T) {
+	convey.Convey("CheckProxyConnect", t, func() {
+		target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer target.Close()
+		targetAddr := target.Listener.Addr().String()
+		tlsConfig := &tls.Config{RootCAs: target.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs, ServerName: "example.com"}
+
+		convey.Convey("Connect and tls handshake succeed.", func() {
+			proxy := newConnectProxy()
+			defer proxy.Close()
+			connectOk, tlsOk, err := CheckProxyConnect(context.TODO(), proxy.URL, targetAddr, tlsConfig)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(connectOk, convey.ShouldBeTrue)
+			convey.So(tlsOk, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Proxy refuses connect.", func() {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}))
+			defer proxy.Close()
+			connectOk, tlsOk, err := CheckProxyConnect(context.TODO(), proxy.URL, targetAddr, tlsConfig)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(connectOk, convey.ShouldBeFalse)
+			convey.So(tlsOk, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Connect accepted but tls fails.", func() {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer proxy.Close()
+			connectOk, tlsOk, err := CheckProxyConnect(context.TODO(), proxy.URL, targetAddr, tlsConfig)
+			convey.So(err, convey.ShouldBeError)
+			convey.So(connectOk, convey.ShouldBeTrue)
+			convey.So(tlsOk, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Checker reports connect results.", func() {
+			proxy := newConnectProxy()
+			defer proxy.Close()
+			r := NewChecker(&CheckerOptions{ConnectTarget: targetAddr, TlsConfig: tlsConfig}).Check(context.TODO(), proxy.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(r.ConnectOk, convey.ShouldBeTrue)
+			convey.So(r.TlsOk, convey.ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LineFormat 原始代理行格式模板
+//
+// 模板由占位符{host}, {port}, {user}, {pass}, {*}以及其他字符组成, 如"{host}:{port}:{user}:{pass}".
+// {*}匹配任意需要忽略的内容, 模板中的空格匹配一个或多个空格或制表符, 其他字符按原样匹配.
+type LineFormat string
+
+const (
+	// FormatHostPort host:port
+	FormatHostPort LineFormat = "{host}:{port}"
+	// FormatHostPortUserPass host:port:user:pass
+	FormatHostPortUserPass LineFormat = "{host}:{port}:{user}:{pass}"
+	// FormatUserPassAtHostPort user:pass@host:port
+	FormatUserPassAtHostPort LineFormat = "{user}:{pass}@{host}:{port}"
+	// FormatSpaceSeparated host port user pass, 以空格或制表符分隔
+	FormatSpaceSeparated LineFormat = "{host} {port} {user} {pass}"
+)
+
+// BuiltinLineFormats 内置的行格式
+var BuiltinLineFormats = []LineFormat{
+	FormatHostPort,
+	FormatHostPortUserPass,
+	FormatUserPassAtHostPort,
+	FormatSpaceSeparated,
+}
+
+// RawProxy 从原始代理行中解析出的代理
+type RawProxy struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Addr 返回host:port, IPv6会带上方括号
+func (p *RawProxy) Addr() string {
+	return net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+}
+
+// Url 返回带scheme的代理连接串
+//
+// 代理自带用户名密码时优先使用, 否则使用usr和pwd
+func (p *RawProxy) Url(usr string, pwd string) string {
+	u := &url.URL{Scheme: "http", Host: p.Addr()}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	} else if usr != "" && pwd != "" {
+		u.User = url.UserPassword(usr, pwd)
+	}
+	return u.String()
+}
+
+var (
+	// proxySchemes 解析时忽略的行首scheme
+	proxySchemes    = []string{"http://", "https://", "socks5://", "socks5h://"}
+	lineFormatCache sync.Map
+	placeholderReg  = regexp.MustCompile(`\{(host|port|user|pass|\*)\}`)
+	placeholderExpr = map[string]string{
+		"{host}": `(?P<host>\[[0-9a-fA-F:.]+\]|[^\s:@\[\]]+)`,
+		"{port}": `(?P<port>\d+)`,
+		"{user}": `(?P<user>[^\s:@]+)`,
+		"{pass}": `(?P<pass>\S+?)`,
+		"{*}":    `.*?`,
+	}
+)
+
+// compileLineFormat 将行格式编译为正则
+func compileLineFormat(format LineFormat) (*regexp.Regexp, error) {
+	if reg, ok := lineFormatCache.Load(format); ok {
+		return reg.(*regexp.Regexp), nil
+	}
+	tpl := string(format)
+	if !strings.Contains(tpl, "{host}") || !strings.Contains(tpl, "{port}") {
+		return nil, fmt.Errorf("行格式必须包含{host}和{port}. format=%s", format)
+	}
+	var (
+		expr strings.Builder
+		last int
+	)
+	expr.WriteString("^")
+	for _, loc := range placeholderReg.FindAllStringIndex(tpl, -1) {
+		expr.WriteString(quoteLiteral(tpl[last:loc[0]]))
+		expr.WriteString(placeholderExpr[tpl[loc[0]:loc[1]]])
+		last = loc[1]
+	}
+	expr.WriteString(quoteLiteral(tpl[last:]))
+	expr.WriteString("$")
+	reg, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("行格式非法. format=%s, %v", format, err)
+	}
+	lineFormatCache.Store(format, reg)
+	return reg, nil
+}
+
+// quoteLiteral 转义模板中的普通字符, 连续空白匹配一个或多个空格或制表符
+func quoteLiteral(s string) string {
+	var (
+		b       strings.Builder
+		inSpace bool
+	)
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			if !inSpace {
+				b.WriteString(`[ \t]+`)
+			}
+			inSpace = true
+			continue
+		}
+		inSpace = false
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return b.String()
+}
+
+// ParseProxyLine 按行格式解析一行原始代理
+//
+// formats 依次尝试的行格式, 为空时使用FormatHostPort. 行首的http://, https://, socks5://, socks5h://会被忽略.
+// 匹配某个格式但host或port非法时继续尝试下一个格式
+func ParseProxyLine(line string, formats ...LineFormat) (*RawProxy, error) {
+	if len(formats) == 0 {
+		formats = []LineFormat{FormatHostPort}
+	}
+	line = trimScheme(strings.TrimSpace(line))
+	var lastErr error
+	for _, format := range formats {
+		reg, err := compileLineFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		match := reg.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		p := &RawProxy{}
+		var port string
+		for i, name := range reg.SubexpNames() {
+			switch name {
+			case "host":
+				p.Host = strings.TrimSuffix(strings.TrimPrefix(match[i], "["), "]")
+			case "port":
+				port = match[i]
+			case "user":
+				p.Username = match[i]
+			case "pass":
+				p.Password = match[i]
+			}
+		}
+		host, portNum, err := SplitProxyAddr(net.JoinHostPort(p.Host, port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.Host, p.Port = host, portNum
+		return p, nil
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, errors.New("format is incorrect")
+}
+
+// trimScheme 去掉行首的代理scheme, 不区分大小写
+func trimScheme(line string) string {
+	for _, scheme := range proxySchemes {
+		if len(line) >= len(scheme) && strings.EqualFold(line[:len(scheme)], scheme) {
+			return line[len(scheme):]
+		}
+	}
+	return line
+}
@@ -1,7 +1,42 @@
 package util
 
+import (
+	"github.com/zx106kg/go-proxy/geo"
+	"time"
+)
+
 // CheckProxyConnAsyncResult 异步批量检查代理连通性结果返回
 type CheckProxyConnAsyncResult struct {
 	Proxy   string
 	Success bool
 }
+
+// InvalidLine 宽松解析时无法识别的行
+type InvalidLine struct {
+	// Line 行号, 从1开始
+	Line   int
+	Text   string
+	Reason string
+}
+
+// CheckResult Checker的检查结果
+type CheckResult struct {
+	Proxy   string
+	Success bool
+	// Err 检查失败的原因
+	Err error
+	// Latency 连通性检查耗时, 多目标检查时为最慢目标的耗时
+	Latency time.Duration
+	// Targets 多目标检查时各目标的结果
+	Targets []*TargetResult
+	// ExitIp 出口IP, 只有配置了地理位置查询时才会获取
+	ExitIp string
+	// Location 出口IP地理位置
+	Location *geo.Location
+	// Anonymity 匿名级别, 只有配置了AnonymityEchoUrl时才会检测
+	Anonymity AnonymityLevel
+	// ConnectOk 代理是否接受CONNECT, 只有配置了ConnectTarget时才会检测
+	ConnectOk bool
+	// TlsOk 通过CONNECT隧道与目标的TLS握手是否成功
+	TlsOk bool
+}
@@ -4,73 +4,148 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/zx106kg/go-proxy/event"
 	"net"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 )
 
+// defaultCheckTarget 默认连通性检查目标
+const defaultCheckTarget = "http://www.baidu.com"
+
 // CheckProxyConn 检查代理连通性
 //
 // proxy必须完整带有scheme
 func CheckProxyConn(ctx context.Context, proxy string) (ok bool, err error) {
-	urlProxy, err := url.Parse(proxy)
+	statusCode, err := CheckProxyTarget(ctx, proxy, defaultCheckTarget)
 	if err != nil {
-		return false, fmt.Errorf("代理字符串格式错误. %+v", err)
+		return false, err
+	}
+	return statusCode == 200, nil
+}
+
+// CheckProxyTarget 通过代理请求target, 返回状态码
+//
+// proxy必须完整带有scheme
+func CheckProxyTarget(ctx context.Context, proxy string, target string) (statusCode int, err error) {
+	c := &Checker{}
+	defer c.Close()
+	return c.checkTarget(ctx, proxy, target, true)
+}
+
+// GetProxyExitIp 通过代理请求echoUrl, 获取代理的出口IP
+//
+// echoUrl 返回调用方IP的接口, 响应可以是纯文本IP或包含IP的JSON
+func GetProxyExitIp(ctx context.Context, proxy string, echoUrl string) (ip string, err error) {
+	c := &Checker{}
+	defer c.Close()
+	return c.GetExitIp(ctx, proxy, echoUrl)
+}
+
+// GetPublicIp 不经代理请求echoUrl, 获取本机出口IP
+func GetPublicIp(ctx context.Context, echoUrl string) (ip string, err error) {
+	c := &Checker{}
+	defer c.Close()
+	return c.GetExitIp(ctx, "", echoUrl)
+}
+
+// GetPublicIpWithClient 使用client请求echoUrl, 获取本机出口IP. client为空时同GetPublicIp
+func GetPublicIpWithClient(ctx context.Context, client *http.Client, echoUrl string) (ip string, err error) {
+	if client == nil {
+		return GetPublicIp(ctx, echoUrl)
 	}
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyURL(urlProxy),
-		},
-		Timeout: 3 * time.Second,
+	req, err := http.NewRequest(http.MethodGet, echoUrl, nil)
+	if err != nil {
+		return "", fmt.Errorf("创建获取出口IP请求失败. %+v", err)
 	}
-	req, err := http.NewRequest("GET", "http://www.baidu.com", nil)
 	if ctx != nil {
 		req = req.WithContext(ctx)
 	}
-	if err != nil {
-		return false, err
-	}
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36")
-	req.Header.Add("Accept-Encoding", "gzip, deflate, br")
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, err
+		return "", err
+	}
+	return exitIpFromResponse(resp)
+}
+
+// findIp 返回文本中的第一个IP
+func findIp(body string) string {
+	if ip := net.ParseIP(strings.TrimSpace(body)); ip != nil {
+		return ip.String()
+	}
+	fields := strings.FieldsFunc(body, func(r rune) bool {
+		return !(r == '.' || r == ':' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'))
+	})
+	for _, field := range fields {
+		if ip := net.ParseIP(field); ip != nil {
+			return ip.String()
+		}
 	}
-	_ = resp.Body.Close()
-	return resp.StatusCode == 200, nil
+	return ""
 }
 
 // IsContainsProxyOnly 检查文本中是否只包含代理连接串.
 //
-// splitter 分隔符.
-func IsContainsProxyOnly(body string, splitter string) bool {
+// 代理连接串为host:port, host支持IPv4, 方括号包裹的IPv6以及域名.
+//
+// splitter 分隔符, 可以为SplitterAuto.
+//
+// formats 行格式, 为空时只允许host:port
+func IsContainsProxyOnly(body string, splitter string, formats ...LineFormat) bool {
 	if body == "" {
 		return false
 	}
-	vec := strings.Split(body, splitter)
-	reg, _ := regexp.Compile(`^\d+\.\d+\.\d+\.\d+:\d+$`)
+	vec := SplitBody(body, splitter)
 	for _, v := range vec {
 		if v == "" {
 			continue
 		}
-		match := reg.MatchString(v)
-		if !match {
+		if len(formats) == 0 {
+			if !IsValidProxyAddr(v) {
+				return false
+			}
+		} else if _, err := ParseProxyLine(v, formats...); err != nil {
 			return false
 		}
 	}
 	return true
 }
 
+// ParseProxyBody 宽松解析文本, 返回其中所有合法的代理连接串以及非法行
+//
+// splitter 分隔符, 可以为SplitterAuto.
+//
+// formats 行格式, 为空时只允许host:port
+func ParseProxyBody(body string, splitter string, formats ...LineFormat) (proxies []string, invalid []*InvalidLine) {
+	for i, v := range SplitBody(body, splitter) {
+		vTrim := strings.TrimSpace(v)
+		if vTrim == "" {
+			continue
+		}
+		var err error
+		if len(formats) == 0 {
+			_, _, err = SplitProxyAddr(vTrim)
+		} else {
+			_, err = ParseProxyLine(vTrim, formats...)
+		}
+		if err != nil {
+			invalid = append(invalid, &InvalidLine{Line: i + 1, Text: vTrim, Reason: err.Error()})
+			continue
+		}
+		proxies = append(proxies, vTrim)
+	}
+	return proxies, invalid
+}
+
 // GetProxyFromBody 从body中获取代理连接串
 //
-// splitter 分隔符.
+// splitter 分隔符, 可以为SplitterAuto.
 func GetProxyFromBody(body string, splitter string) []string {
 	var proxies []string
-	for _, v := range strings.Split(body, splitter) {
+	for _, v := range SplitBody(body, splitter) {
 		vTrim := strings.TrimSpace(v)
 		if vTrim == "" {
 			continue
@@ -81,7 +156,18 @@ func GetProxyFromBody(body string, splitter string) []string {
 }
 
 // FormatRawProxy 格式化原始代理连接串
-func FormatRawProxy(proxy string, usr string, pwd string) (formatted string, err error) {
+//
+// 支持IPv4, 方括号包裹的IPv6以及域名
+//
+// formats 行格式, 设置时按格式解析, 行内自带的用户名密码优先于usr和pwd
+func FormatRawProxy(proxy string, usr string, pwd string, formats ...LineFormat) (formatted string, err error) {
+	if len(formats) > 0 {
+		p, err := ParseProxyLine(proxy, formats...)
+		if err != nil {
+			return "", err
+		}
+		return p.Url(usr, pwd), nil
+	}
 	if !strings.Contains(proxy, "http://") {
 		proxy = "http://" + proxy
 	}
@@ -89,12 +175,8 @@ func FormatRawProxy(proxy string, usr string, pwd string) (formatted string, err
 	if err != nil {
 		return "", errors.New("format is incorrect")
 	}
-	ip := net.ParseIP(parsed.Hostname())
-	if ip == nil || ip.To4() == nil {
-		return "", errors.New("format is incorrect")
-	}
-	if parsed.Port() == "" {
-		return "", errors.New("format is incorrect")
+	if _, _, err := SplitProxyAddr(parsed.Host); err != nil {
+		return "", err
 	}
 	if usr != "" && pwd != "" {
 		parsed.User = url.UserPassword(usr, pwd)
@@ -105,11 +187,15 @@ func FormatRawProxy(proxy string, usr string, pwd string) (formatted string, err
 // CheckProxiesConnSync 批量检查代理连通性, 同步返回结果
 //
 // succ为测试成功的代理组, fail为测试失败的代理组.
-func CheckProxiesConnSync(ctx context.Context, proxies []string) (succ, fail []string) {
+//
+// observers 每个代理检查完成时回调OnChecked
+func CheckProxiesConnSync(ctx context.Context, proxies []string, observers ...event.Observer) (succ, fail []string) {
+	observer := event.Multi(observers...)
 	c := sync.NewCond(&sync.Mutex{})
 	for _, proxy := range proxies {
 		go func(proxy string) {
-			ok, _ := CheckProxyConn(ctx, proxy)
+			ok, err := CheckProxyConn(ctx, proxy)
+			observer.OnChecked(&event.CheckedEvent{Proxy: proxy, Success: ok, Err: err, Time: time.Now()})
 			c.L.Lock()
 			if ok {
 				succ = append(succ, proxy)
@@ -131,12 +217,16 @@ func CheckProxiesConnSync(ctx context.Context, proxies []string) (succ, fail []s
 // CheckProxiesConnAsync 异步批量检查代理连通性
 //
 // 检查完成时, 立刻通过ch返回结果
-func CheckProxiesConnAsync(ctx context.Context, proxies []string, ch chan *CheckProxyConnAsyncResult) {
+//
+// observers 每个代理检查完成时回调OnChecked
+func CheckProxiesConnAsync(ctx context.Context, proxies []string, ch chan *CheckProxyConnAsyncResult, observers ...event.Observer) {
+	observer := event.Multi(observers...)
 	c := sync.NewCond(&sync.Mutex{})
 	total := len(proxies)
 	for _, proxy := range proxies {
 		go func(proxy string) {
-			ok, _ := CheckProxyConn(ctx, proxy)
+			ok, err := CheckProxyConn(ctx, proxy)
+			observer.OnChecked(&event.CheckedEvent{Proxy: proxy, Success: ok, Err: err, Time: time.Now()})
 			c.L.Lock()
 			total--
 			c.L.Unlock()
@@ -0,0 +1,194 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/zx106kg/go-proxy/auth"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// proxyGet 通过proxy请求target, proxy为空时直连. follow为false时不跟随跳转, 返回跳转响应本身
+func (c *Checker) proxyGet(ctx context.Context, proxy string, target string, header http.Header, follow bool) (*http.Response, error) {
+	var urlProxy *url.URL
+	if proxy != "" {
+		var err error
+		urlProxy, err = url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("代理字符串格式错误. %+v", err)
+		}
+	}
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := auth.ApplyRequest(req, urlProxy, c.options.Authenticator); err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: c.transport(proxy, urlProxy),
+		Timeout:   c.timeout(),
+	}
+	if !follow {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client.Do(req)
+}
+
+// readBody 读取响应内容, 状态码不为200时返回错误
+func readBody(resp *http.Response) (string, error) {
+	buf, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("返回状态码异常, StatusCode=%d", resp.StatusCode)
+	}
+	return string(buf), nil
+}
+
+func (c *Checker) checkTarget(ctx context.Context, proxy string, target string, follow bool) (statusCode int, err error) {
+	header := http.Header{}
+	header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36")
+	header.Add("Accept-Encoding", "gzip, deflate, br")
+	resp, err := c.proxyGet(ctx, proxy, target, header, follow)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (c *Checker) checkTargets(ctx context.Context, proxy string, targets []*CheckTarget) []*TargetResult {
+	results := make([]*TargetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *CheckTarget) {
+			defer wg.Done()
+			start := time.Now()
+			// 不跟随跳转, 以便StatusCodes能匹配302等跳转状态码
+			statusCode, err := c.checkTarget(ctx, proxy, target.Url, false)
+			r := &TargetResult{Url: target.Url, StatusCode: statusCode, Err: err, Latency: time.Since(start)}
+			if err == nil {
+				r.Success = isExpectedStatus(target.StatusCodes, statusCode)
+				if !r.Success {
+					r.Err = fmt.Errorf("目标返回状态码异常, StatusCode=%d", statusCode)
+				}
+			}
+			results[i] = r
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// GetExitIp 通过代理请求echoUrl, 获取代理的出口IP
+func (c *Checker) GetExitIp(ctx context.Context, proxy string, echoUrl string) (ip string, err error) {
+	release := c.hold(proxy)
+	defer release(false)
+	resp, err := c.proxyGet(ctx, proxy, echoUrl, nil, true)
+	if err != nil {
+		return "", err
+	}
+	return exitIpFromResponse(resp)
+}
+
+// exitIpFromResponse 从回显接口的响应中读取出口IP
+func exitIpFromResponse(resp *http.Response) (string, error) {
+	body, err := readBody(resp)
+	if err != nil {
+		return "", fmt.Errorf("获取出口IP失败. %v", err)
+	}
+	ip := findIp(body)
+	if ip == "" {
+		return "", fmt.Errorf("获取出口IP返回内容中不包含IP. 原文: %s", body)
+	}
+	return ip, nil
+}
+
+func (c *Checker) getAnonymity(ctx context.Context, proxy string, echoUrl string, realIp string) (level AnonymityLevel, err error) {
+	resp, err := c.proxyGet(ctx, proxy, echoUrl, nil, true)
+	if err != nil {
+		return AnonymityUnknown, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return AnonymityUnknown, fmt.Errorf("请求回显接口失败. %v", err)
+	}
+	return ClassifyAnonymity(body, realIp), nil
+}
+
+func (c *Checker) connect(ctx context.Context, proxy string, target string) (connectOk, tlsOk bool, err error) {
+	urlProxy, err := url.Parse(proxy)
+	if err != nil {
+		return false, false, fmt.Errorf("代理字符串格式错误. %+v", err)
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return false, false, fmt.Errorf("目标地址格式错误. %+v", err)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	conn, err := c.dial(ctx, "tcp", urlProxy.Host)
+	if err != nil {
+		return false, false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+	}
+	req.Header, err = auth.ProxyHeader(urlProxy, target, c.options.Authenticator)
+	if err != nil {
+		return false, false, err
+	}
+	if err := req.Write(conn); err != nil {
+		return false, false, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return false, false, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, false, fmt.Errorf("代理拒绝CONNECT, StatusCode=%d", resp.StatusCode)
+	}
+
+	config := &tls.Config{}
+	if c.options.TlsConfig != nil {
+		config = c.options.TlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return true, false, fmt.Errorf("TLS握手失败. %v", err)
+	}
+	return true, true, nil
+}
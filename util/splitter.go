@@ -0,0 +1,48 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// SplitterAuto 自动识别分隔符
+//
+// 支持\r\n, \n, \r, <br>, 逗号, 分号以及空格. 换行与<br>优先, 其次逗号, 分号,
+// 只有每一段都形如host:port时才按空格拆分, 以免拆开"host port user pass"格式的行.
+const SplitterAuto = "auto"
+
+var brReg = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// SplitBody 按分隔符拆分文本
+//
+// splitter为SplitterAuto时自动识别分隔符, 并去除每段首尾空白
+func SplitBody(body string, splitter string) []string {
+	if splitter != SplitterAuto {
+		return strings.Split(body, splitter)
+	}
+	normalized := brReg.ReplaceAllString(body, "\n")
+	normalized = strings.ReplaceAll(normalized, "\r\n", "\n")
+	normalized = strings.ReplaceAll(normalized, "\r", "\n")
+	for _, sep := range []string{"\n", ",", ";"} {
+		if strings.Contains(normalized, sep) {
+			return trimAll(strings.Split(normalized, sep))
+		}
+	}
+	fields := strings.Fields(normalized)
+	if len(fields) > 1 {
+		for _, field := range fields {
+			if !strings.Contains(field, ":") {
+				return trimAll([]string{normalized})
+			}
+		}
+		return fields
+	}
+	return trimAll([]string{normalized})
+}
+
+func trimAll(vec []string) []string {
+	for i, v := range vec {
+		vec[i] = strings.TrimSpace(v)
+	}
+	return vec
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestSplitBody(t *testing.T) {
+	convey.Convey("SplitBody", t, func() {
+
+		convey.Convey("Fixed splitter.", func() {
+			convey.So(SplitBody("a:1\r\nb:2", "\r\n"), convey.ShouldResemble, []string{"a:1", "b:2"})
+		})
+
+		convey.Convey("Auto detect separators.", func() {
+			expected := []string{"192.168.0.1:8888", "192.168.0.2:8888"}
+			for _, body := range []string{
+				"192.168.0.1:8888\r\n192.168.0.2:8888",
+				"192.168.0.1:8888\n192.168.0.2:8888",
+				"192.168.0.1:8888\r192.168.0.2:8888",
+				"192.168.0.1:8888, 192.168.0.2:8888",
+				"192.168.0.1:8888;192.168.0.2:8888",
+				"192.168.0.1:8888 192.168.0.2:8888",
+				"192.168.0.1:8888<br>192.168.0.2:8888",
+				"192.168.0.1:8888<BR />192.168.0.2:8888",
+			} {
+				convey.So(SplitBody(body, SplitterAuto), convey.ShouldResemble, expected)
+			}
+		})
+
+		convey.Convey("Auto mode keeps space separated lines.", func() {
+			convey.So(SplitBody("192.168.0.1 8888 usr pwd", SplitterAuto), convey.ShouldResemble, []string{"192.168.0.1 8888 usr pwd"})
+			convey.So(SplitBody("192.168.0.1 8888 usr pwd\r\n192.168.0.2 8888 usr pwd\r\n", SplitterAuto), convey.ShouldResemble,
+				[]string{"192.168.0.1 8888 usr pwd", "192.168.0.2 8888 usr pwd", ""})
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// CheckTarget 检查目标
+type CheckTarget struct {
+	Url string
+	// Weight 权重, 用于WeightedPolicy, 小于等于0时视为1
+	Weight float64
+	// StatusCodes 视为成功的状态码, 默认只有200. 检查时不跟随跳转, 可以直接指定302等状态码
+	StatusCodes []int
+}
+
+// TargetResult 单个目标的检查结果
+type TargetResult struct {
+	Url        string
+	Success    bool
+	StatusCode int
+	Err        error
+	Latency    time.Duration
+}
+
+// ConsensusPolicy 多目标检查结果的判定策略
+type ConsensusPolicy interface {
+	Pass(targets []*CheckTarget, results []*TargetResult) bool
+}
+
+// AllPolicy 全部目标成功
+type AllPolicy struct{}
+
+func (AllPolicy) Pass(_ []*CheckTarget, results []*TargetResult) bool {
+	return countSuccess(results) == len(results)
+}
+
+// AnyPolicy 任一目标成功
+type AnyPolicy struct{}
+
+func (AnyPolicy) Pass(_ []*CheckTarget, results []*TargetResult) bool {
+	return countSuccess(results) > 0
+}
+
+// NOfMPolicy 至少N个目标成功
+type NOfMPolicy struct {
+	N int
+}
+
+func (p NOfMPolicy) Pass(_ []*CheckTarget, results []*TargetResult) bool {
+	return countSuccess(results) >= p.N
+}
+
+// WeightedPolicy 成功目标的权重之和占总权重的比例不低于Threshold
+type WeightedPolicy struct {
+	// Threshold 0到1之间
+	Threshold float64
+}
+
+func (p WeightedPolicy) Pass(targets []*CheckTarget, results []*TargetResult) bool {
+	var total, succ float64
+	for i, target := range targets {
+		weight := target.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		if results[i].Success {
+			succ += weight
+		}
+	}
+	return total > 0 && succ/total >= p.Threshold
+}
+
+func countSuccess(results []*TargetResult) int {
+	var n int
+	for _, r := range results {
+		if r.Success {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckProxyTargets 并发检查代理对多个目标的连通性, 结果与targets一一对应
+func CheckProxyTargets(ctx context.Context, proxy string, targets []*CheckTarget) []*TargetResult {
+	c := &Checker{}
+	defer c.Close()
+	return c.checkTargets(ctx, proxy, targets)
+}
+
+func isExpectedStatus(codes []int, statusCode int) bool {
+	if len(codes) == 0 {
+		return statusCode == 200
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"context"
+	"github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsensusPolicy(t *testing.T) {
+	convey.Convey("ConsensusPolicy", t, func() {
+		targets := []*CheckTarget{{Url: "a", Weight: 3}, {Url: "b"}, {Url: "c"}}
+		results := []*TargetResult{{Success: true}, {Success: false}, {Success: true}}
+
+		convey.So(AllPolicy{}.Pass(targets, results), convey.ShouldBeFalse)
+		convey.So(AnyPolicy{}.Pass(targets, results), convey.ShouldBeTrue)
+		convey.So(NOfMPolicy{N: 2}.Pass(targets, results), convey.ShouldBeTrue)
+		convey.So(NOfMPolicy{N: 3}.Pass(targets, results), convey.ShouldBeFalse)
+		convey.So(WeightedPolicy{Threshold: 0.8}.Pass(targets, results), convey.ShouldBeTrue)
+		convey.So(WeightedPolicy{Threshold: 0.9}.Pass(targets, results), convey.ShouldBeFalse)
+	})
+}
+
+func TestChecker_Targets(t *testing.T) {
+	convey.Convey("Targets", t, func() {
+		// 作为代理时, 对banned.com返回403, redirect.com跳转到banned.com, 其他目标返回200
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Host {
+			case "banned.com":
+				w.WriteHeader(http.StatusForbidden)
+			case "redirect.com":
+				http.Redirect(w, r, "http://banned.com/", http.StatusFound)
+			}
+		}))
+		defer proxy.Close()
+		targets := []*CheckTarget{{Url: "http://ok.com"}, {Url: "http://banned.com"}, {Url: "http://redirect.com", StatusCodes: []int{200, 302}}}
+
+		convey.Convey("All targets must succeed by default.", func() {
+			r := NewChecker(&CheckerOptions{Targets: targets}).Check(context.TODO(), proxy.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+			convey.So(len(r.Targets), convey.ShouldEqual, 3)
+			convey.So(r.Targets[0].Success, convey.ShouldBeTrue)
+			convey.So(r.Targets[1].StatusCode, convey.ShouldEqual, http.StatusForbidden)
+			convey.So(r.Targets[1].Err, convey.ShouldBeError)
+			convey.So(r.Targets[2].Success, convey.ShouldBeTrue)
+			convey.So(r.Targets[2].StatusCode, convey.ShouldEqual, http.StatusFound)
+		})
+
+		convey.Convey("N of M policy.", func() {
+			r := NewChecker(&CheckerOptions{Targets: targets, Policy: NOfMPolicy{N: 2}}).Check(context.TODO(), proxy.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("Unreachable proxy fails every target.", func() {
+			r := NewChecker(&CheckerOptions{Targets: targets, Policy: AnyPolicy{}}).Check(context.TODO(), "http://127.0.0.1:1")
+			convey.So(r.Success, convey.ShouldBeFalse)
+			for _, target := range r.Targets {
+				convey.So(target.Err, convey.ShouldBeError)
+			}
+		})
+	})
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"github.com/zx106kg/go-proxy/auth"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// idleConnTimeout 空闲连接保留时间, 保证遗漏关闭的连接最终被释放
+const idleConnTimeout = 30 * time.Second
+
+// proxyTransport 同一代理复用的Transport
+type proxyTransport struct {
+	transport *http.Transport
+	refs      int
+	warm      bool
+	// warmAt 保留空闲连接的时间, 超过WarmTtl后清理
+	warmAt time.Time
+}
+
+// newTransport 创建经过proxy的Transport, proxy为空时直连
+func (c *Checker) newTransport(proxy *url.URL) *http.Transport {
+	transport := &http.Transport{
+		DialContext:     c.dial,
+		TLSClientConfig: c.options.TlsConfig,
+		IdleConnTimeout: idleConnTimeout,
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+		auth.ApplyTransport(transport, c.options.Authenticator)
+	}
+	return transport
+}
+
+// hold 持有proxy的Transport, 检查期间对同一代理的请求复用连接
+//
+// 调用返回的release释放, 最后一个持有者释放时关闭空闲连接. keep为true时保留空闲连接, 供WarmTransport取出
+func (c *Checker) hold(proxy string) (release func(keep bool)) {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	t := c.entry(proxy)
+	t.refs++
+	return func(keep bool) {
+		c.tmu.Lock()
+		defer c.tmu.Unlock()
+		t.refs--
+		if keep {
+			t.warm = true
+			t.warmAt = c.clock()
+			c.pruneWarm()
+		}
+		if t.refs > 0 || t.warm {
+			return
+		}
+		if t.transport != nil {
+			t.transport.CloseIdleConnections()
+		}
+		if c.transports[proxy] == t {
+			delete(c.transports, proxy)
+		}
+	}
+}
+
+// transport 返回proxy的Transport, 需先调用hold
+func (c *Checker) transport(proxy string, urlProxy *url.URL) *http.Transport {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	t := c.entry(proxy)
+	if t.transport == nil {
+		t.transport = c.newTransport(urlProxy)
+	}
+	return t.transport
+}
+
+// entry 返回proxy对应的记录, 不存在时创建. 需持有tmu
+func (c *Checker) entry(proxy string) *proxyTransport {
+	if c.transports == nil {
+		c.transports = make(map[string]*proxyTransport)
+	}
+	t, ok := c.transports[proxy]
+	if !ok {
+		t = &proxyTransport{}
+		c.transports[proxy] = t
+	}
+	return t
+}
+
+// WarmTransport 取出检查成功后保留了空闲连接的Transport, 需要设置CheckerOptions.KeepWarm
+//
+// 取出后由调用方负责CloseIdleConnections. 没有保留的Transport时返回nil
+func (c *Checker) WarmTransport(proxy string) *http.Transport {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	c.pruneWarm()
+	t, ok := c.transports[proxy]
+	if !ok || !t.warm || t.refs > 0 {
+		return nil
+	}
+	delete(c.transports, proxy)
+	return t.transport
+}
+
+// pruneWarm 关闭超过WarmTtl未取出的保留连接, 避免只检查不取出时Transport无限增长. 需持有tmu
+func (c *Checker) pruneWarm() {
+	ttl := c.options.WarmTtl
+	if ttl <= 0 {
+		ttl = idleConnTimeout
+	}
+	now := c.clock()
+	for proxy, t := range c.transports {
+		if !t.warm || t.refs > 0 || now.Sub(t.warmAt) < ttl {
+			continue
+		}
+		if t.transport != nil {
+			t.transport.CloseIdleConnections()
+		}
+		delete(c.transports, proxy)
+	}
+}
+
+// Close 关闭所有Transport的空闲连接, 包括保留的连接
+func (c *Checker) Close() {
+	c.tmu.Lock()
+	defer c.tmu.Unlock()
+	for proxy, t := range c.transports {
+		if t.transport != nil {
+			t.transport.CloseIdleConnections()
+		}
+		if t.refs == 0 {
+			delete(c.transports, proxy)
+		} else {
+			t.warm = false
+		}
+	}
+}
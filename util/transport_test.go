@@ -0,0 +1,125 @@
+package util
+
+import (
+	"context"
+	"github.com/smartystreets/goconvey/convey"
+	"github.com/zx106kg/go-proxy/geo"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connCounter 统计代理服务的连接
+type connCounter struct {
+	mu     sync.Mutex
+	opened int
+	active map[net.Conn]bool
+}
+
+func (c *connCounter) connState(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		c.opened++
+		c.active[conn] = true
+	case http.StateClosed, http.StateHijacked:
+		delete(c.active, conn)
+	}
+}
+
+func (c *connCounter) counts() (opened, active int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opened, len(c.active)
+}
+
+func TestChecker_Transport(t *testing.T) {
+	convey.Convey("Transport reuse", t, func() {
+		counter := &connCounter{active: map[net.Conn]bool{}}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("1.0.0.1"))
+		}))
+		server.Config.ConnState = counter.connState
+		server.Start()
+		defer server.Close()
+		waitClosed := func() int {
+			for i := 0; i < 50; i++ {
+				if _, active := counter.counts(); active == 0 {
+					return 0
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			_, active := counter.counts()
+			return active
+		}
+
+		convey.Convey("Connections are closed after each check.", func() {
+			db, _ := geo.LoadCSV(strings.NewReader("1.0.0.0,1.0.0.255,US"))
+			c := NewChecker(&CheckerOptions{Targets: []*CheckTarget{{Url: "http://example.com/"}}, IpEchoUrl: "http://echo.local/", Geo: db})
+			r := c.Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			convey.So(r.Location.Country, convey.ShouldEqual, "US")
+			convey.So(waitClosed(), convey.ShouldEqual, 0)
+			convey.So(c.WarmTransport(server.URL) == nil, convey.ShouldBeTrue)
+		})
+
+		convey.Convey("KeepWarm keeps the connection for immediate use.", func() {
+			c := NewChecker(&CheckerOptions{KeepWarm: true})
+			r := c.Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeTrue)
+			// 连接在后台放回空闲池
+			time.Sleep(50 * time.Millisecond)
+			transport := c.WarmTransport(server.URL)
+			convey.So(transport != nil, convey.ShouldBeTrue)
+			convey.So(c.WarmTransport(server.URL) == nil, convey.ShouldBeTrue)
+
+			resp, err := (&http.Client{Transport: transport}).Get("http://example.com/")
+			convey.So(err, convey.ShouldBeNil)
+			_ = resp.Body.Close()
+			opened, _ := counter.counts()
+			convey.So(opened, convey.ShouldEqual, 1)
+
+			transport.CloseIdleConnections()
+			convey.So(waitClosed(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Failed checks are not kept warm.", func() {
+			c := NewChecker(&CheckerOptions{KeepWarm: true, Targets: []*CheckTarget{{Url: "http://example.com/", StatusCodes: []int{204}}}})
+			r := c.Check(context.TODO(), server.URL)
+			convey.So(r.Success, convey.ShouldBeFalse)
+			convey.So(c.WarmTransport(server.URL) == nil, convey.ShouldBeTrue)
+			convey.So(waitClosed(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("Warmed connections expire after ttl.", func() {
+			now := time.Unix(1700000000, 0)
+			c := NewChecker(&CheckerOptions{KeepWarm: true, WarmTtl: 10 * time.Second})
+			c.now = func() time.Time { return now }
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer other.Close()
+
+			convey.So(c.Check(context.TODO(), server.URL).Success, convey.ShouldBeTrue)
+			now = now.Add(10 * time.Second)
+			convey.So(c.Check(context.TODO(), other.URL).Success, convey.ShouldBeTrue)
+			convey.So(c.WarmTransport(server.URL) == nil, convey.ShouldBeTrue)
+			convey.So(waitClosed(), convey.ShouldEqual, 0)
+
+			transport := c.WarmTransport(other.URL)
+			convey.So(transport != nil, convey.ShouldBeTrue)
+			transport.CloseIdleConnections()
+		})
+
+		convey.Convey("Close releases warmed connections.", func() {
+			c := NewChecker(&CheckerOptions{KeepWarm: true})
+			convey.So(c.Check(context.TODO(), server.URL).Success, convey.ShouldBeTrue)
+			c.Close()
+			convey.So(c.WarmTransport(server.URL) == nil, convey.ShouldBeTrue)
+			convey.So(waitClosed(), convey.ShouldEqual, 0)
+		})
+	})
+}